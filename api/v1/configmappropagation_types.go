@@ -33,14 +33,188 @@ type ConfigMapPropagationSpec struct {
 
 	// +kubebuilder:validation:Required
 	Target PropagationTarget `json:"target"`
+
+	// Targets changes the target configmaps in the namespaces each entry selects. The namespaces are propagated
+	// to in addition to the ones selected by Target, unless they are excluded by Target.
+	// The target configmaps get the content of the source, with the keys selected by Keys, renamed by KeyMappings
+	// and rendered by Template. Then the data, labels and annotations of the matching entries are merged on top,
+	// in the order of the entries, so that later entries win. The annotations of the ConfigMapPropagation itself
+	// can't be overridden.
+	// +kubebuilder:validation:Optional
+	Targets []TargetOverride `json:"targets,omitempty"`
+
+	// MissingNamespacePolicy defines what happens when a target namespace does not exist or is terminating.
+	// Wait keeps the target pending until the namespace appears, Skip reports the target as not propagated
+	// and Create creates the namespace.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=Wait
+	MissingNamespacePolicy MissingNamespacePolicy `json:"missingNamespacePolicy,omitempty"`
+
+	// DeletionPolicy defines what happens to the target configmaps when the ConfigMapPropagation is deleted.
+	// Delete deletes them and Orphan keeps them.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=Delete
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// ConflictPolicy defines what happens when a target configmap already exists and is not propagated by
+	// this ConfigMapPropagation. Fail reports the conflict as a failure, Skip leaves the existing configmap
+	// alone, Adopt takes over configmaps that are not managed by any ConfigMapPropagation and Overwrite
+	// takes over any configmap.
+	// Targets that other ConfigMapPropagations propagate to are arbitrated by Priority.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=Fail
+	ConflictPolicy ConflictPolicy `json:"conflictPolicy,omitempty"`
+
+	// Priority decides which ConfigMapPropagation writes a target that multiple ConfigMapPropagations
+	// propagate to. The one with the highest priority wins, then the oldest one, then the one with the
+	// name that sorts first. The others report the target as conflicted and leave it alone.
+	// +kubebuilder:validation:Optional
+	Priority int32 `json:"priority,omitempty"`
+
+	// MaxConcurrency is the number of target configmaps written at the same time while reconciling.
+	// Defaults to the --max-concurrent-propagations flag of the controller, which also caps it.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	MaxConcurrency int32 `json:"maxConcurrency,omitempty"`
+
+	// DriftPolicy defines what happens when a target configmap is changed by someone else while its source
+	// stays the same. Ignore leaves the change alone until the source changes, Report leaves the change alone
+	// and reports the target as drifted, and Revert restores the target.
+	// A source change to a value someone else changed is not applied, the target reports a
+	// FieldManagerConflict until the other change is undone.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=Revert
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+
+	// SyncMode defines what happens to the keys of the target configmaps that don't come from the sources.
+	// Replace removes them, so that the targets have exactly the keys of the sources. Merge leaves them alone
+	// and only manages the keys that came from the sources, which are removed when they are removed from the sources.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=Replace
+	SyncMode SyncMode `json:"syncMode,omitempty"`
+
+	// Keys selects the keys of the data and binaryData of the source configmaps that are propagated.
+	// When not set, all keys are propagated.
+	// +kubebuilder:validation:Optional
+	Keys *KeyFilter `json:"keys,omitempty"`
+
+	// KeyMappings renames the keys of the data and binaryData of the source configmaps in the target configmaps.
+	// The first mapping matching a key is applied, keys not matched by any mapping keep their names.
+	// Mappings are applied to the keys selected by Keys.
+	// +kubebuilder:validation:Optional
+	KeyMappings []KeyMapping `json:"keyMappings,omitempty"`
+
+	// Template renders the data values of the source configmaps as Go templates for each target namespace.
+	// +kubebuilder:validation:Optional
+	Template *ValueTemplate `json:"template,omitempty"`
+
+	// MetadataPolicy selects the labels and annotations of the source configmaps that are copied to the targets.
+	// When not set, all labels and all annotations except the ones of tools like kubectl are copied.
+	// The targets are labelled with the fixed labels of the policy, app.kubernetes.io/managed-by=kubegoodies by default.
+	// +kubebuilder:validation:Optional
+	MetadataPolicy *MetadataPolicy `json:"metadataPolicy,omitempty"`
+
+	// Aggregation merges all the source configmaps into a single target configmap in each target namespace,
+	// instead of propagating each source configmap to its own target.
+	// +kubebuilder:validation:Optional
+	Aggregation *Aggregation `json:"aggregation,omitempty"`
 }
 
+// MissingNamespacePolicy defines what happens when a target namespace does not exist or is terminating.
+// +kubebuilder:validation:Enum=Wait;Skip;Create
+type MissingNamespacePolicy string
+
+const (
+	// MissingNamespacePolicyWait waits for the namespace to be created without reporting a failure.
+	MissingNamespacePolicyWait MissingNamespacePolicy = "Wait"
+
+	// MissingNamespacePolicySkip skips the namespace and reports the target as not propagated.
+	MissingNamespacePolicySkip MissingNamespacePolicy = "Skip"
+
+	// MissingNamespacePolicyCreate creates the namespace before propagating into it.
+	MissingNamespacePolicyCreate MissingNamespacePolicy = "Create"
+)
+
+// AllNamespaces is the source namespace that watches all namespaces.
+const AllNamespaces = "*"
+
+// DeletionPolicy defines what happens to the target configmaps when the ConfigMapPropagation is deleted.
+// +kubebuilder:validation:Enum=Delete;Orphan
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete deletes the target configmaps.
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+
+	// DeletionPolicyOrphan keeps the target configmaps.
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+)
+
+// ConflictPolicy defines what happens when a target configmap already exists and is not propagated by the
+// ConfigMapPropagation.
+// +kubebuilder:validation:Enum=Fail;Skip;Adopt;Overwrite
+type ConflictPolicy string
+
+const (
+	// ConflictPolicyFail leaves the existing configmap alone and fails the propagation.
+	ConflictPolicyFail ConflictPolicy = "Fail"
+
+	// ConflictPolicySkip leaves the existing configmap alone without failing the propagation.
+	ConflictPolicySkip ConflictPolicy = "Skip"
+
+	// ConflictPolicyAdopt takes over existing configmaps that are not managed by any ConfigMapPropagation.
+	ConflictPolicyAdopt ConflictPolicy = "Adopt"
+
+	// ConflictPolicyOverwrite takes over any existing configmap.
+	ConflictPolicyOverwrite ConflictPolicy = "Overwrite"
+)
+
+// DriftPolicy defines what happens when a target configmap is changed by someone else while its source stays the same.
+// +kubebuilder:validation:Enum=Ignore;Report;Revert
+type DriftPolicy string
+
+const (
+	// DriftPolicyIgnore leaves the changed target alone until the source changes.
+	DriftPolicyIgnore DriftPolicy = "Ignore"
+
+	// DriftPolicyReport leaves the changed target alone and reports it as drifted.
+	DriftPolicyReport DriftPolicy = "Report"
+
+	// DriftPolicyRevert restores the changed target.
+	DriftPolicyRevert DriftPolicy = "Revert"
+)
+
+// SyncMode defines what happens to the keys of the target configmaps that don't come from the sources.
+// +kubebuilder:validation:Enum=Replace;Merge
+type SyncMode string
+
+const (
+	// SyncModeReplace removes the keys that don't come from the sources.
+	SyncModeReplace SyncMode = "Replace"
+
+	// SyncModeMerge leaves the keys that don't come from the sources alone.
+	SyncModeMerge SyncMode = "Merge"
+)
+
+// PropagationSource selects the configmaps to propagate.
+// The source namespaces are the combination of Namespace, Namespaces and NamespaceSelector.
+// When the configmaps can come from more than one namespace, the targets are named
+// "<source namespace>.<source name>" so that configmaps with the same name don't overwrite each other.
 // +kubebuilder:validation:MinProperties=2
 type PropagationSource struct {
-	// Namespaces is a list of namespaces to watch for configmaps.
+	// Namespace is the namespace to watch for configmaps.
 	// Type * to watch all namespaces.
+	// +kubebuilder:validation:Optional
 	// +kubebuilder:validation:MinLength=1
-	Namespace string `json:"namespace"`
+	Namespace string `json:"namespace,omitempty"`
+
+	// Namespaces is a list of namespaces to watch for configmaps.
+	// +kubebuilder:validation:Optional
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// NamespaceSelector selects the namespaces to watch for configmaps by their labels.
+	// +kubebuilder:validation:Optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
 
 	// Names is the list of configmaps to propagate.
 	// Either specify Names or ObjectSelector.
@@ -53,10 +227,166 @@ type PropagationSource struct {
 	ObjectSelector *metav1.LabelSelector `json:"objectSelector,omitempty"`
 }
 
+// +kubebuilder:validation:MinProperties=1
 type PropagationTarget struct {
-	// +kubebuilder:validation:Required
+	// Namespaces is the list of namespaces to propagate the configmaps to.
+	// +kubebuilder:validation:Optional
 	// +kubebuilder:validation:MinItems=1
-	Namespaces []string `json:"namespaces"`
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// NamespaceSelector selects the namespaces to propagate the configmaps to by their labels.
+	// The selected namespaces are added to the ones listed in Namespaces.
+	// +kubebuilder:validation:Optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// ExcludeNamespaces is the list of namespaces that never receive the configmaps, even when they are
+	// listed in Namespaces or selected by NamespaceSelector.
+	// +kubebuilder:validation:Optional
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+
+	// NameTemplate is a Go template for the names of the target configmaps, like
+	// "{{.SourceNamespace}}-{{.SourceName}}". It can refer to .SourceNamespace, .SourceName, .TargetNamespace
+	// and .PropagationName, and must render a valid configmap name. When not set, the targets are named
+	// like their sources, or <source namespace>.<source name> when the sources are in multiple namespaces.
+	// Sources whose names render the same in a namespace are reported as duplicates.
+	// +kubebuilder:validation:Optional
+	NameTemplate string `json:"nameTemplate,omitempty"`
+}
+
+// TargetOverride changes the target configmaps in the namespaces it selects, by its name or by a selector.
+type TargetOverride struct {
+	// Name identifies the entry in the status.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Namespace is the name of the namespace the entry applies to. Exactly one of Namespace and
+	// NamespaceSelector must be set.
+	// +kubebuilder:validation:Optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// NamespaceSelector selects the namespaces the entry applies to by their labels.
+	// +kubebuilder:validation:Optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Data is added to the data of the target configmaps, replacing the values of the same keys.
+	// +kubebuilder:validation:Optional
+	Data map[string]string `json:"data,omitempty"`
+
+	// Labels are added to the labels of the target configmaps, replacing the values of the same keys.
+	// +kubebuilder:validation:Optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations are added to the annotations of the target configmaps, replacing the values of the same keys.
+	// +kubebuilder:validation:Optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// KeyFilter selects keys by glob patterns, where "*" matches any sequence of characters and "?" matches
+// a single character. A key is selected when it matches an include pattern and no exclude pattern.
+type KeyFilter struct {
+	// Include is the list of patterns of the selected keys. When empty, all keys are selected.
+	// +kubebuilder:validation:Optional
+	Include []string `json:"include,omitempty"`
+
+	// Exclude is the list of patterns of the keys that are never selected, even when they match Include.
+	// +kubebuilder:validation:Optional
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// MetadataPolicy selects the labels and annotations of the source configmaps that are copied to the targets.
+// The patterns are the ones of KeyFilter, where "*" also matches the "/" of prefixed keys, so "*" selects all keys
+// and keys with a prefix are selected by patterns like "example.com/*".
+type MetadataPolicy struct {
+	// Labels selects the labels that are copied. When not set, all labels are copied.
+	// +kubebuilder:validation:Optional
+	Labels *KeyFilter `json:"labels,omitempty"`
+
+	// Annotations selects the annotations that are copied. When not set, all annotations are copied.
+	// +kubebuilder:validation:Optional
+	Annotations *KeyFilter `json:"annotations,omitempty"`
+
+	// KeepToolAnnotations copies the annotations that tools like kubectl, Helm or Argo CD keep on the source
+	// configmaps for their own bookkeeping, like kubectl.kubernetes.io/last-applied-configuration.
+	// They are not copied by default.
+	// +kubebuilder:validation:Optional
+	KeepToolAnnotations bool `json:"keepToolAnnotations,omitempty"`
+
+	// FixedLabels are set on all the targets and can't be overridden by the labels of the sources or the targets,
+	// so that the targets can be queried by them. When not set, the targets are labelled with
+	// app.kubernetes.io/managed-by=kubegoodies. Set it to {} to not label the targets.
+	// +kubebuilder:validation:Optional
+	FixedLabels map[string]string `json:"fixedLabels,omitempty"`
+}
+
+// Aggregation merges the source configmaps into a single target configmap.
+// The sources are merged in the order of their namespaces and names. Keys, KeyMappings and MetadataPolicy
+// apply to each source before merging, Template and Targets to the merged configmap.
+type Aggregation struct {
+	// TargetName is the name of the target configmap in each target namespace.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	TargetName string `json:"targetName"`
+
+	// KeyCollisionStrategy defines what happens when several sources have the same key.
+	// Error fails the propagation, FirstWins and LastWins keep the value of the first or last source,
+	// and PrefixSourceName prefixes all the keys with the name of their source and a dot.
+	// The keys of sources selected in multiple namespaces are prefixed with <namespace>.<name>. instead.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=Error
+	KeyCollisionStrategy KeyCollisionStrategy `json:"keyCollisionStrategy,omitempty"`
+}
+
+// KeyCollisionStrategy defines what happens when aggregated source configmaps have the same key.
+// +kubebuilder:validation:Enum=Error;FirstWins;LastWins;PrefixSourceName
+type KeyCollisionStrategy string
+
+const (
+	// KeyCollisionStrategyError fails the propagation.
+	KeyCollisionStrategyError KeyCollisionStrategy = "Error"
+
+	// KeyCollisionStrategyFirstWins keeps the value of the first source.
+	KeyCollisionStrategyFirstWins KeyCollisionStrategy = "FirstWins"
+
+	// KeyCollisionStrategyLastWins keeps the value of the last source.
+	KeyCollisionStrategyLastWins KeyCollisionStrategy = "LastWins"
+
+	// KeyCollisionStrategyPrefixSourceName prefixes all the keys with the name of their source, avoiding collisions.
+	// The namespace of the source is prefixed too when the sources are selected in multiple namespaces.
+	KeyCollisionStrategyPrefixSourceName KeyCollisionStrategy = "PrefixSourceName"
+)
+
+// KeyMapping renames a key of the source configmaps in the target configmaps.
+type KeyMapping struct {
+	// From is the key to rename. When Regex is set, it is a regular expression matching the whole keys to rename.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	From string `json:"from"`
+
+	// To is the new name of the key. When Regex is set, it can refer to the capture groups of From, like $1 or ${name}.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	To string `json:"to"`
+
+	// Regex makes From a regular expression.
+	// +kubebuilder:validation:Optional
+	Regex bool `json:"regex,omitempty"`
+}
+
+// ValueTemplate defines the rendering of the data values of the source configmaps as Go templates.
+// The templates can refer to the target namespace as .Namespace.Name, .Namespace.Labels and .Namespace.Annotations,
+// and to the variables as .Vars. Referring to a missing label, annotation or variable with dots, like
+// .Namespace.Labels.tenant, fails the target. The builtin index function returns "" for missing keys instead.
+// Use mustIndex to fail the target for keys that can't be written with dots, like
+// {{mustIndex .Namespace.Labels "example.com/tenant"}}.
+type ValueTemplate struct {
+	// Enabled turns on the rendering of the data values. The binaryData values are never rendered.
+	// +kubebuilder:validation:Optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Variables are the values the templates can refer to as .Vars.
+	// +kubebuilder:validation:Optional
+	Variables map[string]string `json:"variables,omitempty"`
 }
 
 // ConfigMapPropagationStatus defines the observed state of ConfigMapPropagation
@@ -64,25 +394,60 @@ type ConfigMapPropagationStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
+	// ObservedGeneration is the generation of the spec the status is based on.
+	// +kubebuilder:validation:Optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
 	// Conditions represent the latest available observations of an object's state
 	// +kubebuilder:validation:Optional
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 
+	// TargetNamespaces is the list of namespaces matched by the target and the reason each of them was matched.
+	// +kubebuilder:validation:Optional
+	TargetNamespaces []TargetNamespaceStatus `json:"targetNamespaces,omitempty"`
+
 	// PropagationStatus is the list of status of each propagation.
 	// +kubebuilder:validation:Optional
 	PropagationStatus []PropagationStatus `json:"propagationStatus,omitempty"`
+
+	// PrunedTargets is the number of stale target configmaps deleted in the last reconciliation.
+	// +kubebuilder:validation:Optional
+	PrunedTargets int32 `json:"prunedTargets,omitempty"`
+
+	// DriftedTargets is the number of target configmaps found drifted from their source and left alone
+	// in the last reconciliation.
+	// +kubebuilder:validation:Optional
+	DriftedTargets int32 `json:"driftedTargets,omitempty"`
+
+	// DriftCorrections is the total number of times a drifted target configmap was restored.
+	// +kubebuilder:validation:Optional
+	DriftCorrections int64 `json:"driftCorrections,omitempty"`
+}
+
+type TargetNamespaceStatus struct {
+	// Name is the name of the target namespace.
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+
+	// Reason is why the namespace was matched. One of Listed, Selected.
+	// +kubebuilder:validation:Required
+	Reason string `json:"reason"`
 }
 
 type PropagationStatus struct {
 
-	// SourceNamespace is the namespace of the source configmap.
+	// SourceNamespace is the namespace of the source configmap. Empty for aggregated targets.
 	// +kubebuilder:validation:Required
 	SourceNamespace string `json:"sourceNamespace"`
 
-	// SourceName is the name of the source configmap.
+	// SourceName is the name of the source configmap. Empty for aggregated targets.
 	// +kubebuilder:validation:Required
 	SourceName string `json:"sourceName"`
 
+	// AggregatedSources is the list of the source configmaps merged into an aggregated target, as namespace/name.
+	// +kubebuilder:validation:Optional
+	AggregatedSources []string `json:"aggregatedSources,omitempty"`
+
 	// TargetNamespace is the namespace of the target configmap.
 	// +kubebuilder:validation:Required
 	TargetNamespace string `json:"targetNamespace"`
@@ -107,6 +472,15 @@ type PropagationStatus struct {
 	// +kubebuilder:validation:Required
 	// +kubebuilder:validation:MaxLength=32768
 	Message string `json:"message"`
+
+	// FilteredKeys is the list of keys of the source configmap that are not propagated.
+	// +kubebuilder:validation:Optional
+	FilteredKeys []string `json:"filteredKeys,omitempty"`
+
+	// AppliedOverrides is the list of the names of the entries of Targets applied to the target configmap,
+	// in the order they are applied.
+	// +kubebuilder:validation:Optional
+	AppliedOverrides []string `json:"appliedOverrides,omitempty"`
 }
 
 const (
@@ -115,6 +489,22 @@ const (
 
 	// ConfigMapPropagationConditionTypeCollectedExecutionRequests is set when the ConfigMapPropagation has collected all execution requests.
 	ConfigMapPropagationConditionTypeCollectedExecutionRequests = "CollectedExecutionRequests"
+
+	// ConfigMapPropagationConditionTypeConflicted is set when some targets of the ConfigMapPropagation are
+	// propagated by another source or by another ConfigMapPropagation that takes precedence.
+	ConfigMapPropagationConditionTypeConflicted = "Conflicted"
+
+	// ConfigMapPropagationConditionTypeSpecValid is set when the ConfigMapPropagation spec has been validated.
+	// It is false when the spec has problems the CRD schema can't catch, such as an invalid selector.
+	ConfigMapPropagationConditionTypeSpecValid = "SpecValid"
+)
+
+const (
+	// TargetNamespaceReasonListed is used when the target namespace is listed in spec.target.namespaces.
+	TargetNamespaceReasonListed = "Listed"
+
+	// TargetNamespaceReasonSelected is used when the target namespace is selected by spec.target.namespaceSelector.
+	TargetNamespaceReasonSelected = "Selected"
 )
 
 //+kubebuilder:object:root=true
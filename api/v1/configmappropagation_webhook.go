@@ -0,0 +1,85 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	"github.com/aliok/kubegoodies/pkg/nametemplate"
+)
+
+// log is for logging in this package.
+var configmappropagationlog = logf.Log.WithName("configmappropagation-resource")
+
+func (r *ConfigMapPropagation) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-kubegoodies-aliok-github-com-v1-configmappropagation,mutating=false,failurePolicy=fail,sideEffects=None,groups=kubegoodies.aliok.github.com,resources=configmappropagations,verbs=create;update,versions=v1,name=vconfigmappropagation.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &ConfigMapPropagation{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *ConfigMapPropagation) ValidateCreate() error {
+	configmappropagationlog.Info("validate create", "name", r.Name)
+
+	return r.validateConfigMapPropagation()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *ConfigMapPropagation) ValidateUpdate(old runtime.Object) error {
+	configmappropagationlog.Info("validate update", "name", r.Name)
+
+	// a propagation that is being deleted must stay updatable, e.g. to remove its finalizer,
+	// even when it was created before its name template became invalid
+	if r.DeletionTimestamp != nil {
+		return nil
+	}
+	if oldPr, ok := old.(*ConfigMapPropagation); ok && oldPr.Spec.Target.NameTemplate == r.Spec.Target.NameTemplate {
+		return nil
+	}
+
+	return r.validateConfigMapPropagation()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *ConfigMapPropagation) ValidateDelete() error {
+	// nothing to validate on deletion
+	return nil
+}
+
+// validateConfigMapPropagation checks the parts of the spec that can't be validated by the CRD schema.
+func (r *ConfigMapPropagation) validateConfigMapPropagation() error {
+	var allErrs field.ErrorList
+
+	if err := nametemplate.Validate(r.Spec.Target.NameTemplate); err != nil {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "target", "nameTemplate"), r.Spec.Target.NameTemplate, err.Error()))
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "ConfigMapPropagation"}, r.Name, allErrs)
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateUpdate(t *testing.T) {
+	invalid := "{{ .Unknown }}"
+	now := metav1.Now()
+
+	tests := []struct {
+		name        string
+		oldTemplate string
+		newTemplate string
+		deleting    bool
+		wantErr     bool
+	}{
+		{name: "valid", oldTemplate: invalid, newTemplate: "{{ .SourceName }}"},
+		{name: "changed to invalid", newTemplate: invalid, wantErr: true},
+		{name: "unchanged invalid", oldTemplate: invalid, newTemplate: invalid},
+		{name: "being deleted", newTemplate: invalid, deleting: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldPr := &ConfigMapPropagation{Spec: ConfigMapPropagationSpec{Target: PropagationTarget{NameTemplate: tt.oldTemplate}}}
+			newPr := &ConfigMapPropagation{Spec: ConfigMapPropagationSpec{Target: PropagationTarget{NameTemplate: tt.newTemplate}}}
+			if tt.deleting {
+				newPr.DeletionTimestamp = &now
+			}
+
+			if err := newPr.ValidateUpdate(oldPr); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateUpdate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
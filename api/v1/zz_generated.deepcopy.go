@@ -26,6 +26,21 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Aggregation) DeepCopyInto(out *Aggregation) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Aggregation.
+func (in *Aggregation) DeepCopy() *Aggregation {
+	if in == nil {
+		return nil
+	}
+	out := new(Aggregation)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ConfigMapPropagation) DeepCopyInto(out *ConfigMapPropagation) {
 	*out = *in
@@ -90,6 +105,38 @@ func (in *ConfigMapPropagationSpec) DeepCopyInto(out *ConfigMapPropagationSpec)
 	*out = *in
 	in.Source.DeepCopyInto(&out.Source)
 	in.Target.DeepCopyInto(&out.Target)
+	if in.Targets != nil {
+		in, out := &in.Targets, &out.Targets
+		*out = make([]TargetOverride, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Keys != nil {
+		in, out := &in.Keys, &out.Keys
+		*out = new(KeyFilter)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.KeyMappings != nil {
+		in, out := &in.KeyMappings, &out.KeyMappings
+		*out = make([]KeyMapping, len(*in))
+		copy(*out, *in)
+	}
+	if in.Template != nil {
+		in, out := &in.Template, &out.Template
+		*out = new(ValueTemplate)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MetadataPolicy != nil {
+		in, out := &in.MetadataPolicy, &out.MetadataPolicy
+		*out = new(MetadataPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Aggregation != nil {
+		in, out := &in.Aggregation, &out.Aggregation
+		*out = new(Aggregation)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigMapPropagationSpec.
@@ -112,10 +159,17 @@ func (in *ConfigMapPropagationStatus) DeepCopyInto(out *ConfigMapPropagationStat
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.TargetNamespaces != nil {
+		in, out := &in.TargetNamespaces, &out.TargetNamespaces
+		*out = make([]TargetNamespaceStatus, len(*in))
+		copy(*out, *in)
+	}
 	if in.PropagationStatus != nil {
 		in, out := &in.PropagationStatus, &out.PropagationStatus
 		*out = make([]PropagationStatus, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 }
 
@@ -129,9 +183,91 @@ func (in *ConfigMapPropagationStatus) DeepCopy() *ConfigMapPropagationStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyFilter) DeepCopyInto(out *KeyFilter) {
+	*out = *in
+	if in.Include != nil {
+		in, out := &in.Include, &out.Include
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Exclude != nil {
+		in, out := &in.Exclude, &out.Exclude
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyFilter.
+func (in *KeyFilter) DeepCopy() *KeyFilter {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyFilter)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyMapping) DeepCopyInto(out *KeyMapping) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyMapping.
+func (in *KeyMapping) DeepCopy() *KeyMapping {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyMapping)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetadataPolicy) DeepCopyInto(out *MetadataPolicy) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = new(KeyFilter)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = new(KeyFilter)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.FixedLabels != nil {
+		in, out := &in.FixedLabels, &out.FixedLabels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetadataPolicy.
+func (in *MetadataPolicy) DeepCopy() *MetadataPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(MetadataPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PropagationSource) DeepCopyInto(out *PropagationSource) {
 	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Names != nil {
 		in, out := &in.Names, &out.Names
 		*out = make([]string, len(*in))
@@ -157,6 +293,21 @@ func (in *PropagationSource) DeepCopy() *PropagationSource {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PropagationStatus) DeepCopyInto(out *PropagationStatus) {
 	*out = *in
+	if in.AggregatedSources != nil {
+		in, out := &in.AggregatedSources, &out.AggregatedSources
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.FilteredKeys != nil {
+		in, out := &in.FilteredKeys, &out.FilteredKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AppliedOverrides != nil {
+		in, out := &in.AppliedOverrides, &out.AppliedOverrides
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PropagationStatus.
@@ -177,6 +328,16 @@ func (in *PropagationTarget) DeepCopyInto(out *PropagationTarget) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ExcludeNamespaces != nil {
+		in, out := &in.ExcludeNamespaces, &out.ExcludeNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PropagationTarget.
@@ -188,3 +349,81 @@ func (in *PropagationTarget) DeepCopy() *PropagationTarget {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TargetNamespaceStatus) DeepCopyInto(out *TargetNamespaceStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TargetNamespaceStatus.
+func (in *TargetNamespaceStatus) DeepCopy() *TargetNamespaceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TargetNamespaceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TargetOverride) DeepCopyInto(out *TargetOverride) {
+	*out = *in
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Data != nil {
+		in, out := &in.Data, &out.Data
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TargetOverride.
+func (in *TargetOverride) DeepCopy() *TargetOverride {
+	if in == nil {
+		return nil
+	}
+	out := new(TargetOverride)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ValueTemplate) DeepCopyInto(out *ValueTemplate) {
+	*out = *in
+	if in.Variables != nil {
+		in, out := &in.Variables, &out.Variables
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ValueTemplate.
+func (in *ValueTemplate) DeepCopy() *ValueTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(ValueTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubegoodiesv1 "github.com/aliok/kubegoodies/api/v1"
+	"github.com/aliok/kubegoodies/pkg/configmappropagation"
+)
+
+// outranks returns true when the propagation a takes precedence over the propagation b for the targets
+// they both propagate to: the one with the highest priority wins, then the oldest one, then the one with
+// the name that sorts first. Propagations that are being deleted never win.
+func outranks(a, b *kubegoodiesv1.ConfigMapPropagation) bool {
+	if a.DeletionTimestamp.IsZero() != b.DeletionTimestamp.IsZero() {
+		return a.DeletionTimestamp.IsZero()
+	}
+	if a.Spec.Priority != b.Spec.Priority {
+		return a.Spec.Priority > b.Spec.Priority
+	}
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return a.Name < b.Name
+}
+
+// arbitrate makes sure that each target is written by a single source of a single propagation.
+// Within the propagation, the first request for a target wins. Across propagations, the target goes to the
+// propagation that outranks the others claiming it in their status.
+// It returns the requests the propagation wins, which may take the target over from the propagations they
+// win against, and the statuses of the requests it loses.
+func (r *ConfigMapPropagationReconciler) arbitrate(ctx context.Context, pr *kubegoodiesv1.ConfigMapPropagation, executionReqs []configmappropagation.Request) ([]configmappropagation.Request, []kubegoodiesv1.PropagationStatus, error) {
+	var won []configmappropagation.Request
+	var lost []kubegoodiesv1.PropagationStatus
+
+	claimed := map[types.NamespacedName]configmappropagation.Request{}
+
+	for _, executionReq := range executionReqs {
+		target := types.NamespacedName{Namespace: executionReq.TargetNamespace, Name: executionReq.TargetName}
+
+		if first, ok := claimed[target]; ok {
+			lost = append(lost, kubegoodiesv1.PropagationStatus{
+				SourceNamespace:   executionReq.SourceNamespace,
+				SourceName:        executionReq.SourceName,
+				AggregatedSources: aggregatedSources(&executionReq),
+				TargetNamespace:   executionReq.TargetNamespace,
+				TargetName:        executionReq.TargetName,
+				Status:            metav1.ConditionFalse,
+				Reason:            "DuplicateTarget",
+				Message:           fmt.Sprintf("Target is already propagated from %s/%s", first.SourceNamespace, first.SourceName),
+			})
+			continue
+		}
+		claimed[target] = executionReq
+
+		var claimants kubegoodiesv1.ConfigMapPropagationList
+		if err := r.List(ctx, &claimants, client.MatchingFields{claimedTargetIndexKey: target.String()}); err != nil {
+			return nil, nil, fmt.Errorf("unable to list ConfigMapPropagations claiming target %s: %v", target, err)
+		}
+
+		var winner *kubegoodiesv1.ConfigMapPropagation
+		contested := false
+		for i := range claimants.Items {
+			other := &claimants.Items[i]
+			if other.UID == pr.UID {
+				continue
+			}
+			contested = true
+			if outranks(other, pr) && (winner == nil || outranks(other, winner)) {
+				winner = other
+			}
+		}
+
+		if winner != nil {
+			lost = append(lost, kubegoodiesv1.PropagationStatus{
+				SourceNamespace:   executionReq.SourceNamespace,
+				SourceName:        executionReq.SourceName,
+				AggregatedSources: aggregatedSources(&executionReq),
+				TargetNamespace:   executionReq.TargetNamespace,
+				TargetName:        executionReq.TargetName,
+				Status:            metav1.ConditionFalse,
+				Reason:            "TargetClaimed",
+				Message:           fmt.Sprintf("Target is propagated by ConfigMapPropagation %s, which takes precedence", winner.Name),
+			})
+			continue
+		}
+
+		// no other propagation outranks this one, the target can be taken over from the ones claiming it.
+		// Targets no other propagation claims, like the ones orphaned by a deleted propagation, are left to
+		// the conflict policy.
+		executionReq.Preempt = contested
+		won = append(won, executionReq)
+	}
+
+	return won, lost, nil
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	kubegoodiesv1 "github.com/aliok/kubegoodies/api/v1"
+	"github.com/aliok/kubegoodies/pkg/configmappropagation"
+)
+
+func TestOutranks(t *testing.T) {
+	older := metav1.NewTime(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	newer := metav1.NewTime(time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC))
+
+	propagation := func(name string, priority int32, created metav1.Time, deleting bool) *kubegoodiesv1.ConfigMapPropagation {
+		pr := &kubegoodiesv1.ConfigMapPropagation{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: created},
+			Spec:       kubegoodiesv1.ConfigMapPropagationSpec{Priority: priority},
+		}
+		if deleting {
+			pr.DeletionTimestamp = &newer
+		}
+		return pr
+	}
+
+	tests := []struct {
+		name string
+		a    *kubegoodiesv1.ConfigMapPropagation
+		b    *kubegoodiesv1.ConfigMapPropagation
+		want bool
+	}{
+		{
+			name: "higher priority wins over older",
+			a:    propagation("a", 10, newer, false),
+			b:    propagation("b", 0, older, false),
+			want: true,
+		},
+		{
+			name: "lower priority loses",
+			a:    propagation("a", 0, older, false),
+			b:    propagation("b", 10, newer, false),
+			want: false,
+		},
+		{
+			name: "older wins with same priority",
+			a:    propagation("b", 0, older, false),
+			b:    propagation("a", 0, newer, false),
+			want: true,
+		},
+		{
+			name: "name decides when created at the same time",
+			a:    propagation("a", 0, older, false),
+			b:    propagation("b", 0, older, false),
+			want: true,
+		},
+		{
+			name: "deleted propagation never wins",
+			a:    propagation("a", 10, older, true),
+			b:    propagation("b", 0, newer, false),
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := outranks(tt.a, tt.b); got != tt.want {
+				t.Errorf("outranks() = %v, want %v", got, tt.want)
+			}
+			if tt.want && outranks(tt.b, tt.a) {
+				t.Errorf("outranks() is not antisymmetric")
+			}
+		})
+	}
+}
+
+func TestArbitrate(t *testing.T) {
+	ctx := context.Background()
+	older := metav1.NewTime(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	newer := metav1.NewTime(time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC))
+
+	claiming := func(name string, priority int32, created metav1.Time, targets ...string) *kubegoodiesv1.ConfigMapPropagation {
+		pr := &kubegoodiesv1.ConfigMapPropagation{
+			ObjectMeta: metav1.ObjectMeta{Name: name, UID: "uid-" + types.UID(name), CreationTimestamp: created},
+			Spec:       kubegoodiesv1.ConfigMapPropagationSpec{Priority: priority},
+		}
+		for _, target := range targets {
+			pr.Status.PropagationStatus = append(pr.Status.PropagationStatus, kubegoodiesv1.PropagationStatus{
+				SourceNamespace: "default",
+				SourceName:      "cm",
+				TargetNamespace: "ns1",
+				TargetName:      target,
+			})
+		}
+		return pr
+	}
+
+	pr := claiming("mine", 5, newer, "unclaimed", "contested", "lost")
+	r := &ConfigMapPropagationReconciler{Client: newTestClient(
+		pr,
+		claiming("lower", 0, older, "contested"),
+		claiming("higher", 10, older, "lost"),
+	)}
+
+	request := func(target string) configmappropagation.Request {
+		return configmappropagation.Request{
+			SourceNamespace: "default",
+			SourceName:      "cm",
+			TargetNamespace: "ns1",
+			TargetName:      target,
+			OwnerName:       pr.Name,
+			OwnerUID:        pr.UID,
+			ConflictPolicy:  kubegoodiesv1.ConflictPolicyFail,
+		}
+	}
+
+	won, lost, err := r.arbitrate(ctx, pr, []configmappropagation.Request{
+		request("unclaimed"), request("contested"), request("lost"), request("unclaimed"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(won) != 2 || won[0].TargetName != "unclaimed" || won[1].TargetName != "contested" {
+		t.Fatalf("unexpected won requests %v", won)
+	}
+	if won[0].Preempt {
+		t.Errorf("expected no preemption of a target no other propagation claims")
+	}
+	if !won[1].Preempt {
+		t.Errorf("expected preemption of a target claimed by an outranked propagation")
+	}
+
+	if len(lost) != 2 || lost[0].Reason != "TargetClaimed" || lost[0].TargetName != "lost" || lost[1].Reason != "DuplicateTarget" {
+		t.Fatalf("unexpected lost statuses %v", lost)
+	}
+
+	// a copy orphaned by another propagation is left to the conflict policy
+	orphaned := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "unclaimed", Annotations: map[string]string{}}}
+	configmappropagation.SetPropagationAnnotation(orphaned.Annotations, "default", "cm")
+	configmappropagation.SetOwnerAnnotation(orphaned.Annotations, "deleted", "uid-deleted")
+	cl := newTestClient(orphaned, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cm"}})
+
+	for _, policy := range []kubegoodiesv1.ConflictPolicy{kubegoodiesv1.ConflictPolicyFail, kubegoodiesv1.ConflictPolicyAdopt} {
+		executionReq := won[0]
+		executionReq.ConflictPolicy = policy
+		var conflictErr *configmappropagation.ConflictError
+		if _, err := configmappropagation.Execute(ctx, cl, &executionReq); !errors.As(err, &conflictErr) {
+			t.Errorf("expected a conflict with the %s policy, got %v", policy, err)
+		}
+	}
+}
+
+func TestFindPropagationsWaitingForConfigMap(t *testing.T) {
+	propagation := func(name string, statuses ...kubegoodiesv1.PropagationStatus) *kubegoodiesv1.ConfigMapPropagation {
+		return &kubegoodiesv1.ConfigMapPropagation{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     kubegoodiesv1.ConfigMapPropagationStatus{PropagationStatus: statuses},
+		}
+	}
+	itemStatus := func(target string, status metav1.ConditionStatus, reason string) kubegoodiesv1.PropagationStatus {
+		return kubegoodiesv1.PropagationStatus{TargetNamespace: "target", TargetName: target, Status: status, Reason: reason}
+	}
+
+	r := &ConfigMapPropagationReconciler{Client: newTestClient(
+		propagation("winner", itemStatus("cm", metav1.ConditionTrue, "PropagationSucceeded")),
+		propagation("loser", itemStatus("cm", metav1.ConditionFalse, "TargetClaimed")),
+		propagation("skipped", itemStatus("cm", metav1.ConditionFalse, "TargetConflict"), itemStatus("other", metav1.ConditionTrue, "PropagationSucceeded")),
+		propagation("elsewhere", itemStatus("other", metav1.ConditionFalse, "TargetClaimed")),
+	)}
+
+	var got []string
+	for _, req := range r.findPropagationsWaitingForConfigMap(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "target", Name: "cm"}}) {
+		got = append(got, req.Name)
+	}
+	sort.Strings(got)
+	if want := []string{"loser", "skipped"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("findPropagationsWaitingForConfigMap() = %v, want %v", got, want)
+	}
+}
@@ -18,16 +18,32 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
 
 	"github.com/aliok/kubegoodies/pkg/configmappropagation"
+	"github.com/aliok/kubegoodies/pkg/nametemplate"
 
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	meta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
@@ -36,15 +52,28 @@ import (
 	"github.com/hashicorp/go-multierror"
 )
 
+// propagationFinalizer is the finalizer that lets the controller clean up the targets of a deleted propagation.
+const propagationFinalizer = "kubegoodies.aliok.github.com/cleanup-targets"
+
 // ConfigMapPropagationReconciler reconciles a ConfigMapPropagation object
 type ConfigMapPropagationReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// MaxConcurrentPropagations is the number of target configmaps a reconciliation writes at the same time.
+	// ConfigMapPropagations can set a lower maxConcurrency.
+	MaxConcurrentPropagations int
+	// MaxConcurrentReconciles is the number of ConfigMapPropagations reconciled at the same time.
+	MaxConcurrentReconciles int
 }
 
 //+kubebuilder:rbac:groups=kubegoodies.aliok.github.com,resources=configmappropagations,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=kubegoodies.aliok.github.com,resources=configmappropagations/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=kubegoodies.aliok.github.com,resources=configmappropagations/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch;create
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -67,109 +96,1153 @@ func (r *ConfigMapPropagationReconciler) Reconcile(ctx context.Context, req ctrl
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	var executionReqs []configmappropagation.Request
-	if len(pr.Spec.Source.Names) > 0 {
-		for _, srcConfigmapName := range pr.Spec.Source.Names {
-			for _, targetNs := range pr.Spec.Target.Namespaces {
-				executionReqs = append(executionReqs, configmappropagation.Request{
-					SourceNamespace: pr.Spec.Source.Namespace,
-					SourceName:      srcConfigmapName,
-					TargetNamespace: targetNs,
-					TargetName:      srcConfigmapName,
-				})
-			}
-		}
+	if !pr.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, r.finalize(ctx, &pr)
 	}
 
-	if pr.Spec.Source.ObjectSelector != nil {
-		var srcConfigmapList corev1.ConfigMapList
-		if err := r.List(ctx, &srcConfigmapList, client.InNamespace(pr.Spec.Source.Namespace), client.MatchingLabels(pr.Spec.Source.ObjectSelector.MatchLabels)); err != nil {
-			logger.Error(err, "unable to list ConfigMaps")
+	if !controllerutil.ContainsFinalizer(&pr, propagationFinalizer) {
+		controllerutil.AddFinalizer(&pr, propagationFinalizer)
+		if err := r.Update(ctx, &pr); err != nil {
+			logger.Error(err, "unable to add finalizer to ConfigMapPropagation")
 			return ctrl.Result{}, err
 		}
+	}
+
+	if reason, message := validateSpec(&pr.Spec); reason != "" {
+		// an invalid spec can't be fixed by a requeue, we'll get notified when the spec changes
+		logger.Info("invalid ConfigMapPropagation spec", "reason", reason, "message", message)
+		setCondition(&pr, metav1.Condition{
+			Type:    kubegoodiesv1.ConfigMapPropagationConditionTypeSpecValid,
+			Status:  metav1.ConditionFalse,
+			Reason:  reason,
+			Message: message,
+		})
+		setCondition(&pr, metav1.Condition{
+			Type:    kubegoodiesv1.ConfigMapPropagationConditionTypeReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  "InvalidSpec",
+			Message: fmt.Sprintf("ConfigMapPropagation %s/%s has an invalid spec: %s", pr.Namespace, pr.Name, message),
+		})
+		return ctrl.Result{}, r.updateStatus(ctx, &pr)
+	}
+
+	setCondition(&pr, metav1.Condition{
+		Type:    kubegoodiesv1.ConfigMapPropagationConditionTypeSpecValid,
+		Status:  metav1.ConditionTrue,
+		Reason:  "SpecValid",
+		Message: fmt.Sprintf("ConfigMapPropagation %s/%s has a valid spec", pr.Namespace, pr.Name),
+	})
+
+	targetNamespaces, err := r.resolveTargetNamespaces(ctx, &pr)
+	if err != nil {
+		logger.Error(err, "unable to resolve target namespaces")
+		return ctrl.Result{}, r.failReconcile(ctx, &pr, "ResolvingTargetNamespacesFailed", err)
+	}
+	pr.Status.TargetNamespaces = targetNamespaces
+
+	sources, err := r.collectSources(ctx, &pr)
+	if err != nil {
+		logger.Error(err, "unable to collect source ConfigMaps")
+		return ctrl.Result{}, r.failReconcile(ctx, &pr, "CollectingSourcesFailed", err)
+	}
+
+	sourceCms, err := r.loadSources(ctx, sources)
+	if err != nil {
+		logger.Error(err, "unable to load source ConfigMaps")
+		return ctrl.Result{}, r.failReconcile(ctx, &pr, "CollectingSourcesFailed", err)
+	}
+
+	overrides, err := r.matchTargetOverrides(ctx, &pr, targetNamespaces)
+	if err != nil {
+		logger.Error(err, "unable to match target overrides")
+		return ctrl.Result{}, r.failReconcile(ctx, &pr, "ResolvingTargetNamespacesFailed", err)
+	}
+
+	multiNamespaceSource := isMultiNamespaceSource(&pr.Spec.Source)
+
+	var nameTemplate *template.Template
+	if pr.Spec.Target.NameTemplate != "" {
+		// already validated with the spec
+		nameTemplate, _ = nametemplate.Parse(pr.Spec.Target.NameTemplate)
+	}
+
+	var errs error
+
+	// recreate the status array so that we create it from scratch
+	// TODO: shall we create the items in advance with status=Unknown?
+	var itemStatuses []kubegoodiesv1.PropagationStatus
+
+	var executionReqs []configmappropagation.Request
+	if pr.Spec.Aggregation != nil {
+		executionReqs = newAggregationRequests(&pr, sources, sourceCms, targetNamespaces, overrides)
+	} else {
+		for _, src := range sources {
+			for _, targetNs := range targetNamespaces {
+				var targetName string
+				var err error
+				if nameTemplate != nil {
+					targetName, err = nametemplate.Render(nameTemplate, nametemplate.Data{
+						SourceNamespace: src.Namespace,
+						SourceName:      src.Name,
+						TargetNamespace: targetNs.Name,
+						PropagationName: pr.Name,
+					})
+				} else {
+					targetName, err = defaultTargetName(src, multiNamespaceSource)
+				}
+				if err != nil {
+					logger.Info("invalid target name", "source", src, "namespace", targetNs.Name, "error", err.Error())
+					errs = multierror.Append(errs, fmt.Errorf("error naming the target for source %s in namespace %s: %v", src, targetNs.Name, err))
+					propagationsTotal.WithLabelValues(pr.Name, metricResultFailed).Inc()
+
+					itemStatuses = append(itemStatuses, kubegoodiesv1.PropagationStatus{
+						SourceNamespace: src.Namespace,
+						SourceName:      src.Name,
+						TargetNamespace: targetNs.Name,
+						Status:          metav1.ConditionFalse,
+						Reason:          "InvalidTargetName",
+						Message:         fmt.Sprintf("error naming the target %v", err),
+					})
+					continue
+				}
+
+				if src.Namespace == targetNs.Name && src.Name == targetName {
+					// never propagate a ConfigMap onto itself
+					continue
+				}
 
-		for _, srcConfigmap := range srcConfigmapList.Items {
-			for _, targetNs := range pr.Spec.Target.Namespaces {
 				executionReqs = append(executionReqs, configmappropagation.Request{
-					SourceNamespace: pr.Spec.Source.Namespace,
-					SourceName:      srcConfigmap.Name,
-					TargetNamespace: targetNs,
-					TargetName:      srcConfigmap.Name,
+					SourceNamespace: src.Namespace,
+					SourceName:      src.Name,
+					Source:          sourceCms[src],
+					TargetNamespace: targetNs.Name,
+					TargetName:      targetName,
+					OwnerName:       pr.Name,
+					OwnerUID:        pr.UID,
+					ConflictPolicy:  pr.Spec.ConflictPolicy,
+					DriftPolicy:     pr.Spec.DriftPolicy,
+					Keys:            pr.Spec.Keys,
+					KeyMappings:     pr.Spec.KeyMappings,
+					Template:        pr.Spec.Template,
+					Overrides:       overrides[targetNs.Name],
+					MetadataPolicy:  pr.Spec.MetadataPolicy,
+					SyncMode:        pr.Spec.SyncMode,
 				})
 			}
 		}
 	}
 
-	// TODO: do we need a sanity check for the case where there is a target configmap which is targeted by multiple source configmaps?
-
 	logger.Info("executionReqs", "executionReqs", executionReqs)
-	meta.SetStatusCondition(&pr.Status.Conditions, metav1.Condition{
+	setCondition(&pr, metav1.Condition{
 		Type:    kubegoodiesv1.ConfigMapPropagationConditionTypeCollectedExecutionRequests,
 		Status:  metav1.ConditionTrue,
 		Reason:  "CollectedExecutionRequests",
 		Message: fmt.Sprintf("Collected %d execution requests for ConfigMapPropagation %s/%s", len(executionReqs), pr.Namespace, pr.Name),
 	})
 
-	// recreate the status array so that we create it from scratch
-	// TODO: shall we create the items in advance with status=Unknown?
-	var itemStatuses []kubegoodiesv1.PropagationStatus
+	// a target propagated to by multiple sources or ConfigMapPropagations is only written by one of them
+	wonReqs, conflictStatuses, err := r.arbitrate(ctx, &pr, executionReqs)
+	if err != nil {
+		logger.Error(err, "unable to arbitrate targets")
+		return ctrl.Result{}, r.failReconcile(ctx, &pr, "ArbitratingTargetsFailed", err)
+	}
 
-	var errs error
+	if len(conflictStatuses) > 0 {
+		setCondition(&pr, metav1.Condition{
+			Type:    kubegoodiesv1.ConfigMapPropagationConditionTypeConflicted,
+			Status:  metav1.ConditionTrue,
+			Reason:  "TargetsClaimed",
+			Message: fmt.Sprintf("%d targets of ConfigMapPropagation %s/%s are propagated by another source or ConfigMapPropagation", len(conflictStatuses), pr.Namespace, pr.Name),
+		})
+	} else {
+		setCondition(&pr, metav1.Condition{
+			Type:    kubegoodiesv1.ConfigMapPropagationConditionTypeConflicted,
+			Status:  metav1.ConditionFalse,
+			Reason:  "NoConflicts",
+			Message: fmt.Sprintf("All targets of ConfigMapPropagation %s/%s are propagated by it", pr.Namespace, pr.Name),
+		})
+	}
+
+	namespaceChecks := map[string]namespaceCheck{}
+	events := newEventAggregator(r.Recorder, r.Client, &pr)
+	drifted := 0
+	managed := 0
+
+	// the namespace checks may create namespaces, they are done before the parallel execution
+	checks := make([]namespaceCheck, len(wonReqs))
+	var readyReqs []configmappropagation.Request
+	for i, executionReq := range wonReqs {
+		check, ok := namespaceChecks[executionReq.TargetNamespace]
+		if !ok {
+			var err error
+			check, err = r.checkTargetNamespace(ctx, pr.Spec.MissingNamespacePolicy, executionReq.TargetNamespace)
+			if err != nil {
+				logger.Error(err, "unable to check target namespace", "namespace", executionReq.TargetNamespace)
+				errs = multierror.Append(errs, fmt.Errorf("error checking target namespace %s: %v", executionReq.TargetNamespace, err))
+				check = namespaceCheck{status: metav1.ConditionFalse, reason: "PropagationFailed", message: fmt.Sprintf("error checking target namespace %v", err)}
+			}
+			namespaceChecks[executionReq.TargetNamespace] = check
+		}
+
+		checks[i] = check
+		if check.ready {
+			readyReqs = append(readyReqs, executionReq)
+		}
+	}
+
+	outcomes := r.executeAll(ctx, readyReqs, r.maxConcurrency(&pr))
+
+	next := 0
+	for i, executionReq := range wonReqs {
+		check := checks[i]
+		if !check.ready {
+			itemStatuses = append(itemStatuses, newItemStatus(&executionReq, check.status, check.reason, check.message))
+			continue
+		}
 
-	for _, executionReq := range executionReqs {
 		// TODO: set status condition for each execution request
-		if err := configmappropagation.Execute(ctx, r.Client, &executionReq); err != nil {
-			// TODO: do not return here, but continue with the rest of the requests
-			logger.Error(err, "unable to execute configmap propagation request", "request", executionReq)
-			errs = multierror.Append(errs, fmt.Errorf("error executing request %v: %v", executionReq, err))
-
-			itemStatuses = append(itemStatuses, kubegoodiesv1.PropagationStatus{
-				SourceNamespace: executionReq.SourceNamespace,
-				SourceName:      executionReq.SourceName,
-				TargetNamespace: executionReq.TargetNamespace,
-				TargetName:      executionReq.TargetName,
-				Status:          metav1.ConditionFalse,
-				Reason:          "PropagationFailed",
-				Message:         fmt.Sprintf("error executing request %v", err),
-			})
+		outcome, err := outcomes[next].Outcome, outcomes[next].err
+		result := outcome.Result
+		next++
+		target := types.NamespacedName{Namespace: executionReq.TargetNamespace, Name: executionReq.TargetName}
+
+		if err != nil {
+			reason := failureReason(err)
+			message := err.Error()
+
+			if reason == "TargetConflict" || reason == "FieldManagerConflict" {
+				conflictsTotal.WithLabelValues(pr.Name).Inc()
+			}
+			// a skipped conflict is expected, only this target fails otherwise and the others are propagated
+			if reason != "TargetConflict" || pr.Spec.ConflictPolicy != kubegoodiesv1.ConflictPolicySkip {
+				errs = multierror.Append(errs, fmt.Errorf("error executing request %v: %v", executionReq, err))
+				propagationsTotal.WithLabelValues(pr.Name, metricResultFailed).Inc()
+			}
+
+			if reason == "PropagationFailed" {
+				logger.Error(err, "unable to execute configmap propagation request", "request", executionReq)
+				events.record(ctx, corev1.EventTypeWarning, reason, target, fmt.Sprintf("Propagating from %s failed: %v", strings.Join(executionReq.Sources(), ", "), err))
+				message = fmt.Sprintf("error executing request %v", err)
+			} else {
+				logger.Info("unable to propagate target configmap", "request", executionReq, "reason", reason, "error", err.Error())
+				events.record(ctx, corev1.EventTypeWarning, reason, target, err.Error())
+			}
+
+			itemStatuses = append(itemStatuses, newItemStatus(&executionReq, metav1.ConditionFalse, reason, message))
+		} else if result == configmappropagation.ResultDriftDetected {
+			// reported, but not a failure: the target is left alone on purpose
+			drifted++
+			managed++
+			propagationsTotal.WithLabelValues(pr.Name, metricResult(result)).Inc()
+			events.record(ctx, corev1.EventTypeWarning, "TargetDrifted", target, "Changed after it was propagated, left alone as the drift policy is Report")
+
+			itemStatuses = append(itemStatuses, newAppliedItemStatus(&executionReq, metav1.ConditionFalse, "TargetDrifted", "Target configmap was changed after it was propagated"))
+		} else if result == configmappropagation.ResultDriftReverted {
+			pr.Status.DriftCorrections++
+			managed++
+			driftCorrectionsTotal.WithLabelValues(pr.Name).Inc()
+			propagationsTotal.WithLabelValues(pr.Name, metricResult(result)).Inc()
+			events.record(ctx, corev1.EventTypeNormal, "DriftReverted", target, fmt.Sprintf("Changed after it was propagated, restored from %s", strings.Join(executionReq.Sources(), ", ")))
+
+			itemStatuses = append(itemStatuses, newAppliedItemStatus(&executionReq, metav1.ConditionTrue, "DriftReverted", "Target configmap was changed after it was propagated and is restored"))
+		} else if outcome.SourceMissing {
+			// the target of a missing source is deleted, it is not managed anymore
+			propagationsTotal.WithLabelValues(pr.Name, metricResult(result)).Inc()
+			message := "Source configmap does not exist, there is no target"
+			if result == configmappropagation.ResultDeleted {
+				events.record(ctx, corev1.EventTypeNormal, "TargetDeleted", target, fmt.Sprintf("Deleted as %s does not exist", strings.Join(executionReq.Sources(), ", ")))
+				message = "Source configmap does not exist, the target is deleted"
+			}
+
+			itemStatuses = append(itemStatuses, newItemStatus(&executionReq, metav1.ConditionFalse, "SourceNotFound", message))
 		} else {
-			itemStatuses = append(itemStatuses, kubegoodiesv1.PropagationStatus{
-				SourceNamespace: executionReq.SourceNamespace,
-				SourceName:      executionReq.SourceName,
-				TargetNamespace: executionReq.TargetNamespace,
-				TargetName:      executionReq.TargetName,
-				Status:          metav1.ConditionTrue,
-				Reason:          "PropagationSucceeded",
-				Message:         fmt.Sprintf("Propagated"),
-			})
+			managed++
+			propagationsTotal.WithLabelValues(pr.Name, metricResult(result)).Inc()
+			if result == configmappropagation.ResultCreated || result == configmappropagation.ResultUpdated {
+				events.record(ctx, corev1.EventTypeNormal, "Target"+string(result), target, fmt.Sprintf("%s from %s", result, strings.Join(executionReq.Sources(), ", ")))
+
+				// writes for new targets, changes of the propagation and reverted drift are not propagations of a change
+				if outcome.SourceChanged {
+					if executionReq.Source != nil {
+						observePropagationLatency(pr.Name, executionReq.Source)
+					} else if len(executionReq.Aggregated) > 0 {
+						observePropagationLatency(pr.Name, executionReq.Aggregated...)
+					}
+				}
+			}
+
+			itemStatuses = append(itemStatuses, newAppliedItemStatus(&executionReq, metav1.ConditionTrue, "PropagationSucceeded", "Propagated"))
 		}
 	}
 
+	itemStatuses = append(itemStatuses, conflictStatuses...)
+	for _, conflictStatus := range conflictStatuses {
+		target := types.NamespacedName{Namespace: conflictStatus.TargetNamespace, Name: conflictStatus.TargetName}
+		events.record(ctx, corev1.EventTypeWarning, conflictStatus.Reason, target, conflictStatus.Message)
+	}
+	conflictsTotal.WithLabelValues(pr.Name).Add(float64(len(conflictStatuses)))
+
+	// the targets of the previous reconciliation that are not wanted anymore
+	pruned, pruneStatuses, err := r.pruneStaleTargets(ctx, &pr, executionReqs, events)
+	if err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	itemStatuses = append(itemStatuses, pruneStatuses...)
+
 	pr.Status.PropagationStatus = itemStatuses
+	pr.Status.PrunedTargets = int32(pruned)
+	pr.Status.DriftedTargets = int32(drifted)
+	propagationsTotal.WithLabelValues(pr.Name, metricResultDeleted).Add(float64(pruned))
+	managedTargets.WithLabelValues(pr.Name).Set(float64(managed))
 
 	if errs != nil {
-		// TODO, update status before returning?
-		return ctrl.Result{}, errs
+		// the status is persisted, the error only makes the request requeued with a backoff
+		failed := 1
+		if merr, ok := errs.(*multierror.Error); ok {
+			failed = len(merr.Errors)
+		}
+		setCondition(&pr, metav1.Condition{
+			Type:    kubegoodiesv1.ConfigMapPropagationConditionTypeReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  "Degraded",
+			Message: fmt.Sprintf("ConfigMapPropagation %s/%s has %d failed propagations, see the propagation status for details", pr.Namespace, pr.Name, failed),
+		})
+	} else {
+		setCondition(&pr, metav1.Condition{
+			Type:    kubegoodiesv1.ConfigMapPropagationConditionTypeReady,
+			Status:  metav1.ConditionTrue,
+			Reason:  "Ready",
+			Message: fmt.Sprintf("ConfigMapPropagation %s/%s is ready", pr.Namespace, pr.Name),
+		})
+	}
+
+	events.flush()
+
+	if err := r.updateStatus(ctx, &pr); err != nil {
+		return ctrl.Result{}, multierror.Append(errs, err)
+	}
+
+	return ctrl.Result{}, errs
+}
+
+// newItemStatus returns the status of the target of the execution request.
+func newItemStatus(executionReq *configmappropagation.Request, status metav1.ConditionStatus, reason string, message string) kubegoodiesv1.PropagationStatus {
+	return kubegoodiesv1.PropagationStatus{
+		SourceNamespace:   executionReq.SourceNamespace,
+		SourceName:        executionReq.SourceName,
+		AggregatedSources: aggregatedSources(executionReq),
+		TargetNamespace:   executionReq.TargetNamespace,
+		TargetName:        executionReq.TargetName,
+		Status:            status,
+		Reason:            reason,
+		Message:           message,
+	}
+}
+
+// newAppliedItemStatus returns the status of a target the execution request was applied to, which lists
+// the keys left out and the overrides applied.
+func newAppliedItemStatus(executionReq *configmappropagation.Request, status metav1.ConditionStatus, reason string, message string) kubegoodiesv1.PropagationStatus {
+	itemStatus := newItemStatus(executionReq, status, reason, message)
+	itemStatus.FilteredKeys = filteredKeys(executionReq)
+	itemStatus.AppliedOverrides = overrideNames(executionReq.Overrides)
+	return itemStatus
+}
+
+// failureReason returns the status reason of the error of an execution request.
+func failureReason(err error) string {
+	var conflictErr *configmappropagation.ConflictError
+	var fieldConflictErr *configmappropagation.FieldManagerConflictError
+	var collisionErr *configmappropagation.KeyMappingCollisionError
+	var keyCollisionErr *configmappropagation.KeyCollisionError
+	var renderErr *configmappropagation.TemplateRenderError
+
+	switch {
+	case errors.As(err, &conflictErr):
+		return "TargetConflict"
+	case errors.As(err, &fieldConflictErr):
+		return "FieldManagerConflict"
+	case errors.As(err, &collisionErr):
+		return "KeyMappingCollision"
+	case errors.As(err, &keyCollisionErr):
+		return "KeyCollision"
+	case errors.As(err, &renderErr):
+		return "TemplateRenderFailed"
+	default:
+		return "PropagationFailed"
+	}
+}
+
+type executeOutcome struct {
+	configmappropagation.Outcome
+	err error
+}
+
+// executeAll executes the requests, at most maxConcurrency of them at a time. The outcomes are returned
+// in the order of the requests, regardless of the order the executions complete in.
+func (r *ConfigMapPropagationReconciler) executeAll(ctx context.Context, executionReqs []configmappropagation.Request, maxConcurrency int) []executeOutcome {
+	outcomes := make([]executeOutcome, len(executionReqs))
+
+	sem := make(chan struct{}, maxConcurrency)
+	var wg sync.WaitGroup
+	for i := range executionReqs {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			outcomes[i].Outcome, outcomes[i].err = configmappropagation.Execute(ctx, r.Client, &executionReqs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	return outcomes
+}
+
+// maxConcurrency returns the number of requests of the propagation executed at the same time.
+// The propagation can lower the limit of the controller, but not raise it.
+func (r *ConfigMapPropagationReconciler) maxConcurrency(pr *kubegoodiesv1.ConfigMapPropagation) int {
+	limit := r.MaxConcurrentPropagations
+	if limit <= 0 {
+		limit = 1
+	}
+	if pr.Spec.MaxConcurrency > 0 && int(pr.Spec.MaxConcurrency) < limit {
+		return int(pr.Spec.MaxConcurrency)
+	}
+	return limit
+}
+
+// setCondition sets the condition on the propagation, recording the generation it is based on.
+func setCondition(pr *kubegoodiesv1.ConfigMapPropagation, condition metav1.Condition) {
+	condition.ObservedGeneration = pr.Generation
+	meta.SetStatusCondition(&pr.Status.Conditions, condition)
+}
+
+// updateStatus persists the status of the propagation, recording the generation it is based on.
+func (r *ConfigMapPropagationReconciler) updateStatus(ctx context.Context, pr *kubegoodiesv1.ConfigMapPropagation) error {
+	logger := log.FromContext(ctx)
+
+	pr.Status.ObservedGeneration = pr.Generation
+	if err := r.Status().Update(ctx, pr); err != nil {
+		logger.Error(err, "unable to update ConfigMapPropagation status")
+		return err
 	}
+	return nil
+}
 
-	meta.SetStatusCondition(&pr.Status.Conditions, metav1.Condition{
+// failReconcile marks the propagation as not ready when the reconciliation fails before the targets are
+// propagated, and returns the error so that the request gets requeued.
+// The propagation status of the previous reconciliation is kept, as the targets are left untouched.
+func (r *ConfigMapPropagationReconciler) failReconcile(ctx context.Context, pr *kubegoodiesv1.ConfigMapPropagation, reason string, err error) error {
+	setCondition(pr, metav1.Condition{
 		Type:    kubegoodiesv1.ConfigMapPropagationConditionTypeReady,
-		Status:  metav1.ConditionTrue,
-		Reason:  "Ready",
-		Message: fmt.Sprintf("ConfigMapPropagation %s/%s is ready", pr.Namespace, pr.Name),
+		Status:  metav1.ConditionFalse,
+		Reason:  reason,
+		Message: fmt.Sprintf("ConfigMapPropagation %s/%s failed to reconcile: %v", pr.Namespace, pr.Name, err),
 	})
+	if updateErr := r.updateStatus(ctx, pr); updateErr != nil {
+		return multierror.Append(err, updateErr)
+	}
+	return err
+}
 
-	if err := r.Status().Update(ctx, &pr); err != nil {
-		logger.Error(err, "unable to update ConfigMapPropagation status")
-		return ctrl.Result{}, err
+// pruneStaleTargets deletes the targets that are owned by the propagation or were propagated in a previous
+// reconciliation but are not part of the given execution requests anymore, e.g. because a source name or a
+// target namespace was removed from the spec, or a source stopped matching the object selector.
+// It returns the number of deleted targets and the statuses of the targets that could not be deleted, which are
+// kept so that the deletion is retried.
+func (r *ConfigMapPropagationReconciler) pruneStaleTargets(ctx context.Context, pr *kubegoodiesv1.ConfigMapPropagation, executionReqs []configmappropagation.Request, events *eventAggregator) (int, []kubegoodiesv1.PropagationStatus, error) {
+	logger := log.FromContext(ctx)
+
+	wanted := map[types.NamespacedName]bool{}
+	for _, executionReq := range executionReqs {
+		wanted[types.NamespacedName{Namespace: executionReq.TargetNamespace, Name: executionReq.TargetName}] = true
+	}
+
+	candidates, err := r.collectKnownTargets(ctx, pr)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	pruned := 0
+	var failed []kubegoodiesv1.PropagationStatus
+	var errs error
+
+	for _, pruneReq := range candidates {
+		target := types.NamespacedName{Namespace: pruneReq.TargetNamespace, Name: pruneReq.TargetName}
+		if wanted[target] {
+			continue
+		}
+
+		result, err := configmappropagation.DeleteTarget(ctx, r.Client, &pruneReq)
+		if err != nil {
+			logger.Error(err, "unable to prune stale target configmap", "request", pruneReq)
+			errs = multierror.Append(errs, fmt.Errorf("error pruning target of request %v: %v", pruneReq, err))
+			events.record(ctx, corev1.EventTypeWarning, "PruneFailed", target, fmt.Sprintf("Pruning stale target failed: %v", err))
+
+			failed = append(failed, newItemStatus(&pruneReq, metav1.ConditionFalse, "PruneFailed", fmt.Sprintf("error pruning stale target %v", err)))
+			continue
+		}
+
+		if result == configmappropagation.ResultDeleted {
+			logger.Info("pruned stale target configmap", "request", pruneReq)
+			pruned++
+			events.record(ctx, corev1.EventTypeNormal, "TargetDeleted", target, "Deleted as it is not a target anymore")
+		}
+	}
+
+	return pruned, failed, errs
+}
+
+// listOwnedTargets returns the target configmaps controlled by the propagation.
+func (r *ConfigMapPropagationReconciler) listOwnedTargets(ctx context.Context, pr *kubegoodiesv1.ConfigMapPropagation) ([]corev1.ConfigMap, error) {
+	var cmList corev1.ConfigMapList
+	if err := r.List(ctx, &cmList, client.MatchingFields{targetOwnerIndexKey: pr.Name}); err != nil {
+		return nil, fmt.Errorf("unable to list owned ConfigMaps: %v", err)
+	}
+
+	var owned []corev1.ConfigMap
+	for _, cm := range cmList.Items {
+		// a propagation with the same name might have been deleted and recreated
+		if ref := metav1.GetControllerOf(&cm); ref != nil && ref.UID == pr.UID {
+			owned = append(owned, cm)
+		}
+	}
+	return owned, nil
+}
+
+// collectKnownTargets returns a request for each target the propagation knows about: the configmaps it owns
+// and the targets in its status, which also covers the targets created before targets had owners.
+func (r *ConfigMapPropagationReconciler) collectKnownTargets(ctx context.Context, pr *kubegoodiesv1.ConfigMapPropagation) ([]configmappropagation.Request, error) {
+	owned, err := r.listOwnedTargets(ctx, pr)
+	if err != nil {
+		return nil, err
+	}
+
+	var reqs []configmappropagation.Request
+	seen := map[types.NamespacedName]bool{}
+	add := func(req configmappropagation.Request) {
+		key := types.NamespacedName{Namespace: req.TargetNamespace, Name: req.TargetName}
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		reqs = append(reqs, req)
+	}
+
+	for _, cm := range owned {
+		req := configmappropagation.Request{
+			TargetNamespace: cm.Namespace,
+			TargetName:      cm.Name,
+			OwnerName:       pr.Name,
+			OwnerUID:        pr.UID,
+		}
+		if src := configmappropagation.GetPropagationAnnotation(cm.Annotations); src != nil {
+			req.SourceNamespace = src.Namespace
+			req.SourceName = src.Name
+		}
+		add(req)
+	}
+
+	for _, itemStatus := range pr.Status.PropagationStatus {
+		if itemStatus.TargetName == "" {
+			// the target name couldn't be rendered, nothing was written
+			continue
+		}
+		add(configmappropagation.Request{
+			SourceNamespace: itemStatus.SourceNamespace,
+			SourceName:      itemStatus.SourceName,
+			TargetNamespace: itemStatus.TargetNamespace,
+			TargetName:      itemStatus.TargetName,
+			OwnerName:       pr.Name,
+			OwnerUID:        pr.UID,
+		})
+	}
+
+	return reqs, nil
+}
+
+// finalize cleans up the targets of a deleted propagation according to its deletion policy and
+// removes the finalizer afterwards.
+// Owned targets would be garbage collected by Kubernetes anyway, so orphaned targets are released first.
+func (r *ConfigMapPropagationReconciler) finalize(ctx context.Context, pr *kubegoodiesv1.ConfigMapPropagation) error {
+	logger := log.FromContext(ctx)
+
+	if !controllerutil.ContainsFinalizer(pr, propagationFinalizer) {
+		return nil
+	}
+
+	var errs error
+	if pr.Spec.DeletionPolicy == kubegoodiesv1.DeletionPolicyOrphan {
+		owned, err := r.listOwnedTargets(ctx, pr)
+		if err != nil {
+			return err
+		}
+		for i := range owned {
+			if err := configmappropagation.ReleaseTarget(ctx, r.Client, &owned[i], pr.UID); err != nil {
+				logger.Error(err, "unable to release target configmap", "configmap", client.ObjectKeyFromObject(&owned[i]))
+				errs = multierror.Append(errs, err)
+			}
+		}
+	} else {
+		targets, err := r.collectKnownTargets(ctx, pr)
+		if err != nil {
+			return err
+		}
+		events := newEventAggregator(r.Recorder, r.Client, pr)
+		for _, deleteReq := range targets {
+			target := types.NamespacedName{Namespace: deleteReq.TargetNamespace, Name: deleteReq.TargetName}
+			result, err := configmappropagation.DeleteTarget(ctx, r.Client, &deleteReq)
+			if err != nil {
+				logger.Error(err, "unable to delete target configmap", "request", deleteReq)
+				errs = multierror.Append(errs, fmt.Errorf("error deleting target of request %v: %v", deleteReq, err))
+				events.record(ctx, corev1.EventTypeWarning, "DeleteFailed", target, fmt.Sprintf("Deleting target failed: %v", err))
+			} else if result == configmappropagation.ResultDeleted {
+				events.record(ctx, corev1.EventTypeNormal, "TargetDeleted", target, "Deleted as the ConfigMapPropagation is deleted")
+			}
+		}
+		events.flush()
+	}
+	if errs != nil {
+		return errs
+	}
+
+	controllerutil.RemoveFinalizer(pr, propagationFinalizer)
+	if err := r.Update(ctx, pr); err != nil {
+		logger.Error(err, "unable to remove finalizer from ConfigMapPropagation")
+		return err
+	}
+
+	deletePropagationMetrics(pr.Name)
+
+	return nil
+}
+
+// validateSpec checks the parts of the spec that can't be validated by the CRD schema.
+// It returns the reason and the message of the first problem found, or an empty reason when the spec is valid.
+func validateSpec(spec *kubegoodiesv1.ConfigMapPropagationSpec) (string, string) {
+	if spec.Source.Namespace == "" && len(spec.Source.Namespaces) == 0 && spec.Source.NamespaceSelector == nil {
+		return "InvalidSource", "spec.source must have at least one of namespace, namespaces and namespaceSelector"
+	}
+
+	if spec.Source.ObjectSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(spec.Source.ObjectSelector); err != nil {
+			return "InvalidObjectSelector", fmt.Sprintf("spec.source.objectSelector is invalid: %v", err)
+		}
+	}
+
+	if spec.Source.NamespaceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(spec.Source.NamespaceSelector); err != nil {
+			return "InvalidNamespaceSelector", fmt.Sprintf("spec.source.namespaceSelector is invalid: %v", err)
+		}
+	}
+
+	if spec.Target.NamespaceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(spec.Target.NamespaceSelector); err != nil {
+			return "InvalidNamespaceSelector", fmt.Sprintf("spec.target.namespaceSelector is invalid: %v", err)
+		}
+	}
+
+	if err := configmappropagation.ValidateKeyFilter(spec.Keys); err != nil {
+		return "InvalidKeyFilter", fmt.Sprintf("spec.keys is invalid: %v", err)
+	}
+
+	if err := configmappropagation.ValidateMetadataPolicy(spec.MetadataPolicy); err != nil {
+		return "InvalidMetadataPolicy", fmt.Sprintf("spec.metadataPolicy is invalid: %v", err)
+	}
+
+	if err := configmappropagation.ValidateKeyMappings(spec.KeyMappings); err != nil {
+		return "InvalidKeyMapping", fmt.Sprintf("spec.keyMappings is invalid: %v", err)
+	}
+
+	names := map[string]bool{}
+	for i, override := range spec.Targets {
+		if names[override.Name] {
+			return "InvalidTargetOverride", fmt.Sprintf("spec.targets[%d].name %q is not unique", i, override.Name)
+		}
+		names[override.Name] = true
+
+		if (override.Namespace == "") == (override.NamespaceSelector == nil) {
+			return "InvalidTargetOverride", fmt.Sprintf("spec.targets[%d] must have exactly one of namespace and namespaceSelector", i)
+		}
+		if override.NamespaceSelector != nil {
+			if _, err := metav1.LabelSelectorAsSelector(override.NamespaceSelector); err != nil {
+				return "InvalidTargetOverride", fmt.Sprintf("spec.targets[%d].namespaceSelector is invalid: %v", i, err)
+			}
+		}
+		for k := range override.Data {
+			if errs := validation.IsConfigMapKey(k); len(errs) > 0 {
+				return "InvalidTargetOverride", fmt.Sprintf("spec.targets[%d].data has the invalid key %q: %s", i, k, strings.Join(errs, ", "))
+			}
+		}
+	}
+
+	// also validated by the webhook, which may not be deployed
+	if err := nametemplate.Validate(spec.Target.NameTemplate); err != nil {
+		return "InvalidNameTemplate", fmt.Sprintf("spec.target.nameTemplate is invalid: %v", err)
+	}
+
+	if spec.Aggregation != nil {
+		if spec.Target.NameTemplate != "" {
+			return "InvalidAggregation", "spec.target.nameTemplate can't be used with spec.aggregation, which names the target"
+		}
+		if errs := validation.IsDNS1123Subdomain(spec.Aggregation.TargetName); len(errs) > 0 {
+			return "InvalidAggregation", fmt.Sprintf("spec.aggregation.targetName is invalid: %s", strings.Join(errs, ", "))
+		}
+	}
+
+	return "", ""
+}
+
+// resolveTargetNamespaces returns the namespaces listed in the target followed by the ones selected by
+// the target's namespace selector, leaving out the excluded namespaces.
+func (r *ConfigMapPropagationReconciler) resolveTargetNamespaces(ctx context.Context, pr *kubegoodiesv1.ConfigMapPropagation) ([]kubegoodiesv1.TargetNamespaceStatus, error) {
+	excluded := map[string]bool{}
+	for _, ns := range pr.Spec.Target.ExcludeNamespaces {
+		excluded[ns] = true
+	}
+
+	var namespaces []kubegoodiesv1.TargetNamespaceStatus
+	matched := map[string]bool{}
+
+	// the namespaces of the overrides are propagated to as well
+	listed := append([]string{}, pr.Spec.Target.Namespaces...)
+	var selectors []*metav1.LabelSelector
+	if pr.Spec.Target.NamespaceSelector != nil {
+		selectors = append(selectors, pr.Spec.Target.NamespaceSelector)
+	}
+	for _, override := range pr.Spec.Targets {
+		if override.Namespace != "" {
+			listed = append(listed, override.Namespace)
+		}
+		if override.NamespaceSelector != nil {
+			selectors = append(selectors, override.NamespaceSelector)
+		}
+	}
+
+	for _, ns := range listed {
+		if excluded[ns] || matched[ns] {
+			continue
+		}
+		matched[ns] = true
+		namespaces = append(namespaces, kubegoodiesv1.TargetNamespaceStatus{Name: ns, Reason: kubegoodiesv1.TargetNamespaceReasonListed})
+	}
+
+	for _, labelSelector := range selectors {
+		selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace selector: %v", err)
+		}
+
+		var nsList corev1.NamespaceList
+		if err := r.List(ctx, &nsList, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			return nil, fmt.Errorf("unable to list namespaces: %v", err)
+		}
+
+		// the order of the list is not guaranteed, keep the status stable
+		sort.Slice(nsList.Items, func(i, j int) bool {
+			return nsList.Items[i].Name < nsList.Items[j].Name
+		})
+
+		for _, ns := range nsList.Items {
+			if excluded[ns.Name] || matched[ns.Name] {
+				continue
+			}
+			matched[ns.Name] = true
+			namespaces = append(namespaces, kubegoodiesv1.TargetNamespaceStatus{Name: ns.Name, Reason: kubegoodiesv1.TargetNamespaceReasonSelected})
+		}
+	}
+
+	return namespaces, nil
+}
+
+// filteredKeys returns the keys of the sources of the request that are not propagated.
+func filteredKeys(executionReq *configmappropagation.Request) []string {
+	if len(executionReq.Aggregated) > 0 {
+		var keys []string
+		seen := map[string]bool{}
+		for _, src := range executionReq.Aggregated {
+			for _, k := range configmappropagation.FilteredKeys(executionReq.Keys, src) {
+				if !seen[k] {
+					seen[k] = true
+					keys = append(keys, k)
+				}
+			}
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	if executionReq.Source == nil {
+		return nil
+	}
+	return configmappropagation.FilteredKeys(executionReq.Keys, executionReq.Source)
+}
+
+// aggregatedSources returns the sources of an aggregating request, nil for the other requests.
+func aggregatedSources(executionReq *configmappropagation.Request) []string {
+	if len(executionReq.Aggregated) == 0 {
+		return nil
+	}
+	return executionReq.Sources()
+}
+
+// newAggregationRequests returns a request for each target namespace, aggregating all the existing sources
+// that are not being deleted. Without such sources there is no request, so that the targets are pruned.
+func newAggregationRequests(pr *kubegoodiesv1.ConfigMapPropagation, sources []types.NamespacedName, sourceCms map[types.NamespacedName]*corev1.ConfigMap, targetNamespaces []kubegoodiesv1.TargetNamespaceStatus, overrides map[string][]kubegoodiesv1.TargetOverride) []configmappropagation.Request {
+	// the sources are sorted by namespace and name, which is their merge order
+	var aggregated []*corev1.ConfigMap
+	for _, src := range sources {
+		if sourceCm := sourceCms[src]; sourceCm != nil && sourceCm.DeletionTimestamp == nil {
+			aggregated = append(aggregated, sourceCm)
+		}
+	}
+	if len(aggregated) == 0 {
+		return nil
+	}
+
+	var executionReqs []configmappropagation.Request
+	for _, targetNs := range targetNamespaces {
+		targetName := pr.Spec.Aggregation.TargetName
+		if _, ok := sourceCms[types.NamespacedName{Namespace: targetNs.Name, Name: targetName}]; ok {
+			// never aggregate onto one of the sources
+			continue
+		}
+
+		executionReqs = append(executionReqs, configmappropagation.Request{
+			TargetNamespace:       targetNs.Name,
+			TargetName:            targetName,
+			OwnerName:             pr.Name,
+			OwnerUID:              pr.UID,
+			ConflictPolicy:        pr.Spec.ConflictPolicy,
+			DriftPolicy:           pr.Spec.DriftPolicy,
+			Keys:                  pr.Spec.Keys,
+			KeyMappings:           pr.Spec.KeyMappings,
+			Template:              pr.Spec.Template,
+			Overrides:             overrides[targetNs.Name],
+			MetadataPolicy:        pr.Spec.MetadataPolicy,
+			SyncMode:              pr.Spec.SyncMode,
+			Aggregated:            aggregated,
+			KeyCollisionStrategy:  pr.Spec.Aggregation.KeyCollisionStrategy,
+			PrefixSourceNamespace: isMultiNamespaceSource(&pr.Spec.Source),
+		})
+	}
+	return executionReqs
+}
+
+// overrideNames returns the names of the overrides, in their order.
+func overrideNames(overrides []kubegoodiesv1.TargetOverride) []string {
+	var names []string
+	for _, override := range overrides {
+		names = append(names, override.Name)
+	}
+	return names
+}
+
+// matchTargetOverrides returns the overrides applying to each target namespace, in the order of the spec.
+func (r *ConfigMapPropagationReconciler) matchTargetOverrides(ctx context.Context, pr *kubegoodiesv1.ConfigMapPropagation, targetNamespaces []kubegoodiesv1.TargetNamespaceStatus) (map[string][]kubegoodiesv1.TargetOverride, error) {
+	if len(pr.Spec.Targets) == 0 {
+		return nil, nil
+	}
+
+	selectors := make([]labels.Selector, len(pr.Spec.Targets))
+	for i, override := range pr.Spec.Targets {
+		if override.NamespaceSelector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(override.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace selector of target override %s: %v", override.Name, err)
+		}
+		selectors[i] = selector
+	}
+
+	overrides := map[string][]kubegoodiesv1.TargetOverride{}
+	for _, targetNs := range targetNamespaces {
+		// only fetched when a selector needs its labels
+		var ns *corev1.Namespace
+
+		for i, override := range pr.Spec.Targets {
+			if selectors[i] == nil {
+				if override.Namespace == targetNs.Name {
+					overrides[targetNs.Name] = append(overrides[targetNs.Name], override)
+				}
+				continue
+			}
+
+			if ns == nil {
+				ns = &corev1.Namespace{}
+				if err := r.Get(ctx, types.NamespacedName{Name: targetNs.Name}, ns); client.IgnoreNotFound(err) != nil {
+					return nil, fmt.Errorf("unable to get namespace %s: %v", targetNs.Name, err)
+				}
+			}
+			if selectors[i].Matches(labels.Set(ns.Labels)) {
+				overrides[targetNs.Name] = append(overrides[targetNs.Name], override)
+			}
+		}
+	}
+	return overrides, nil
+}
+
+// loadSources gets each source ConfigMap once, to be shared by all the requests propagating it.
+// Sources that don't exist anymore are left out, their requests delete the targets.
+func (r *ConfigMapPropagationReconciler) loadSources(ctx context.Context, sources []types.NamespacedName) (map[types.NamespacedName]*corev1.ConfigMap, error) {
+	sourceCms := make(map[types.NamespacedName]*corev1.ConfigMap, len(sources))
+	for _, src := range sources {
+		var sourceCm corev1.ConfigMap
+		if err := r.Get(ctx, src, &sourceCm); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("unable to get source ConfigMap %s: %v", src, err)
+		}
+		sourceCms[src] = &sourceCm
+	}
+	return sourceCms, nil
+}
+
+// defaultTargetName returns the name of the target of the source when there is no name template.
+// Sources that can come from more than one namespace are named <namespace>.<name>, which can't collide
+// as namespace names can't contain dots.
+func defaultTargetName(src types.NamespacedName, multiNamespaceSource bool) (string, error) {
+	if !multiNamespaceSource {
+		return src.Name, nil
+	}
+
+	name := src.Namespace + "." + src.Name
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return "", fmt.Errorf("%q is not a valid configmap name: %s", name, strings.Join(errs, ", "))
+	}
+	return name, nil
+}
+
+// isMultiNamespaceSource returns true when the source ConfigMaps can come from more than one namespace.
+func isMultiNamespaceSource(src *kubegoodiesv1.PropagationSource) bool {
+	if src.Namespace == kubegoodiesv1.AllNamespaces || src.NamespaceSelector != nil {
+		return true
+	}
+
+	namespaces := map[string]bool{}
+	if src.Namespace != "" {
+		namespaces[src.Namespace] = true
+	}
+	for _, ns := range src.Namespaces {
+		namespaces[ns] = true
+	}
+	return len(namespaces) > 1
+}
+
+// resolveSourceNamespaces returns the namespaces listed in the source followed by the ones selected by
+// the source's namespace selector. It returns true instead when the source watches all namespaces.
+func (r *ConfigMapPropagationReconciler) resolveSourceNamespaces(ctx context.Context, src *kubegoodiesv1.PropagationSource) ([]string, bool, error) {
+	if src.Namespace == kubegoodiesv1.AllNamespaces {
+		return nil, true, nil
+	}
+
+	var namespaces []string
+	seen := map[string]bool{}
+	add := func(ns string) {
+		if ns == "" || seen[ns] {
+			return
+		}
+		seen[ns] = true
+		namespaces = append(namespaces, ns)
+	}
+
+	add(src.Namespace)
+	for _, ns := range src.Namespaces {
+		add(ns)
+	}
+
+	if src.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(src.NamespaceSelector)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid source namespace selector: %v", err)
+		}
+
+		var nsList corev1.NamespaceList
+		if err := r.List(ctx, &nsList, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			return nil, false, fmt.Errorf("unable to list namespaces: %v", err)
+		}
+
+		sort.Slice(nsList.Items, func(i, j int) bool {
+			return nsList.Items[i].Name < nsList.Items[j].Name
+		})
+
+		for _, ns := range nsList.Items {
+			add(ns.Name)
+		}
+	}
+
+	return namespaces, false, nil
+}
+
+// collectSources returns the source ConfigMaps of the propagation, sorted by namespace and name.
+// ConfigMaps listed by name in a concrete namespace are returned even when they don't exist, so that
+// their targets get deleted. Other sources are only returned when they exist and are not a propagation
+// target themselves.
+func (r *ConfigMapPropagationReconciler) collectSources(ctx context.Context, pr *kubegoodiesv1.ConfigMapPropagation) ([]types.NamespacedName, error) {
+	src := &pr.Spec.Source
+
+	namespaces, allNamespaces, err := r.resolveSourceNamespaces(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []types.NamespacedName
+	seen := map[types.NamespacedName]bool{}
+	add := func(key types.NamespacedName) {
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		sources = append(sources, key)
+	}
+
+	// the namespaces the existing sources are listed in, an empty namespace lists all namespaces
+	listNamespaces := namespaces
+	if allNamespaces {
+		listNamespaces = []string{""}
+	}
+
+	if len(src.Names) > 0 {
+		if allNamespaces {
+			names := map[string]bool{}
+			for _, name := range src.Names {
+				names[name] = true
+			}
+
+			var cmList corev1.ConfigMapList
+			if err := r.List(ctx, &cmList); err != nil {
+				return nil, fmt.Errorf("unable to list ConfigMaps: %v", err)
+			}
+			for _, cm := range cmList.Items {
+				if names[cm.Name] && !configmappropagation.IsTarget(cm.Annotations) {
+					add(types.NamespacedName{Namespace: cm.Namespace, Name: cm.Name})
+				}
+			}
+		} else {
+			for _, ns := range namespaces {
+				for _, name := range src.Names {
+					add(types.NamespacedName{Namespace: ns, Name: name})
+				}
+			}
+		}
+	}
+
+	if src.ObjectSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(src.ObjectSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source object selector: %v", err)
+		}
+
+		for _, ns := range listNamespaces {
+			var cmList corev1.ConfigMapList
+			if err := r.List(ctx, &cmList, client.InNamespace(ns), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+				return nil, fmt.Errorf("unable to list ConfigMaps: %v", err)
+			}
+			for _, cm := range cmList.Items {
+				if !configmappropagation.IsTarget(cm.Annotations) {
+					add(types.NamespacedName{Namespace: cm.Namespace, Name: cm.Name})
+				}
+			}
+		}
 	}
 
-	return ctrl.Result{}, nil
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].Namespace != sources[j].Namespace {
+			return sources[i].Namespace < sources[j].Namespace
+		}
+		return sources[i].Name < sources[j].Name
+	})
+
+	return sources, nil
+}
+
+// namespaceCheck is the outcome of checking a target namespace before propagating into it.
+type namespaceCheck struct {
+	ready   bool
+	status  metav1.ConditionStatus
+	reason  string
+	message string
+}
+
+// checkTargetNamespace checks that the target namespace exists and is not terminating.
+// Missing namespaces are handled according to the given policy.
+func (r *ConfigMapPropagationReconciler) checkTargetNamespace(ctx context.Context, policy kubegoodiesv1.MissingNamespacePolicy, name string) (namespaceCheck, error) {
+	logger := log.FromContext(ctx)
+
+	var ns corev1.Namespace
+	err := r.Get(ctx, types.NamespacedName{Name: name}, &ns)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return namespaceCheck{}, err
+	}
+
+	if err == nil && (ns.DeletionTimestamp != nil || ns.Status.Phase == corev1.NamespaceTerminating) {
+		// a terminating namespace can neither receive ConfigMaps nor be recreated until it is gone
+		if policy == kubegoodiesv1.MissingNamespacePolicySkip {
+			return namespaceCheck{status: metav1.ConditionFalse, reason: "TargetNamespaceTerminating", message: fmt.Sprintf("Target namespace %s is terminating, skipped", name)}, nil
+		}
+		return namespaceCheck{status: metav1.ConditionUnknown, reason: "TargetNamespaceTerminating", message: fmt.Sprintf("Target namespace %s is terminating, waiting for it to be recreated", name)}, nil
+	}
+
+	if err == nil {
+		return namespaceCheck{ready: true}, nil
+	}
+
+	switch policy {
+	case kubegoodiesv1.MissingNamespacePolicySkip:
+		return namespaceCheck{status: metav1.ConditionFalse, reason: "TargetNamespaceNotFound", message: fmt.Sprintf("Target namespace %s does not exist, skipped", name)}, nil
+	case kubegoodiesv1.MissingNamespacePolicyCreate:
+		ns = corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if err := r.Create(ctx, &ns); err != nil && !apierrors.IsAlreadyExists(err) {
+			return namespaceCheck{}, err
+		}
+		logger.Info("created target namespace", "namespace", name)
+		return namespaceCheck{ready: true}, nil
+	default:
+		return namespaceCheck{status: metav1.ConditionUnknown, reason: "WaitingForTargetNamespace", message: fmt.Sprintf("Target namespace %s does not exist, waiting for it to be created", name)}, nil
+	}
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ConfigMapPropagationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	ctx := context.Background()
+
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &kubegoodiesv1.ConfigMapPropagation{}, sourceNameIndexKey, indexSourceNames); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &kubegoodiesv1.ConfigMapPropagation{}, sourceSelectorIndexKey, indexSourceSelector); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &kubegoodiesv1.ConfigMapPropagation{}, sourceNamespaceSelectorIndexKey, indexSourceNamespaceSelector); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &kubegoodiesv1.ConfigMapPropagation{}, targetNamespaceIndexKey, indexTargetNamespaces); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &kubegoodiesv1.ConfigMapPropagation{}, targetNamespaceSelectorIndexKey, indexTargetNamespaceSelector); err != nil {
+		return err
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &kubegoodiesv1.ConfigMapPropagation{}, claimedTargetIndexKey, indexClaimedTargets); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &corev1.ConfigMap{}, targetOwnerIndexKey, indexTargetOwner); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&kubegoodiesv1.ConfigMapPropagation{}).
+		// re-reconcile the owning propagation when a target changes or gets deleted
+		Owns(&corev1.ConfigMap{}).
+		// re-reconcile the propagations using a ConfigMap as a source when that ConfigMap changes
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.findPropagationsForSourceConfigMap)).
+		// re-reconcile the propagations that couldn't write a target when that target changes or gets deleted
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.findPropagationsWaitingForConfigMap)).
+		// re-reconcile the propagations targeting or watching a namespace when that namespace appears, starts
+		// terminating or has its labels changed
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(r.findPropagationsForNamespace),
+			builder.WithPredicates(namespaceLifecyclePredicate)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Complete(r)
 }
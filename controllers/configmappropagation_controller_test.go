@@ -0,0 +1,1044 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	kubegoodiesv1 "github.com/aliok/kubegoodies/api/v1"
+	"github.com/aliok/kubegoodies/pkg/configmappropagation"
+)
+
+func TestDefaultTargetName(t *testing.T) {
+	tests := []struct {
+		name                 string
+		src                  types.NamespacedName
+		multiNamespaceSource bool
+		want                 string
+		wantErr              bool
+	}{
+		{
+			name: "single namespace",
+			src:  types.NamespacedName{Namespace: "team-a", Name: "config"},
+			want: "config",
+		},
+		{
+			name:                 "multiple namespaces",
+			src:                  types.NamespacedName{Namespace: "team-a", Name: "config"},
+			multiNamespaceSource: true,
+			want:                 "team-a.config",
+		},
+		{
+			name:                 "dashes don't collide",
+			src:                  types.NamespacedName{Namespace: "team", Name: "a-config"},
+			multiNamespaceSource: true,
+			want:                 "team.a-config",
+		},
+		{
+			name:                 "too long",
+			src:                  types.NamespacedName{Namespace: "team-a", Name: strings.Repeat("c", 250)},
+			multiNamespaceSource: true,
+			wantErr:              true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := defaultTargetName(tt.src, tt.multiNamespaceSource)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("defaultTargetName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("defaultTargetName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsMultiNamespaceSource(t *testing.T) {
+	tests := []struct {
+		name string
+		src  kubegoodiesv1.PropagationSource
+		want bool
+	}{
+		{
+			name: "namespace",
+			src:  kubegoodiesv1.PropagationSource{Namespace: "a"},
+			want: false,
+		},
+		{
+			name: "all namespaces",
+			src:  kubegoodiesv1.PropagationSource{Namespace: kubegoodiesv1.AllNamespaces},
+			want: true,
+		},
+		{
+			name: "namespace listed again",
+			src:  kubegoodiesv1.PropagationSource{Namespace: "a", Namespaces: []string{"a"}},
+			want: false,
+		},
+		{
+			name: "multiple namespaces",
+			src:  kubegoodiesv1.PropagationSource{Namespace: "a", Namespaces: []string{"b"}},
+			want: true,
+		},
+		{
+			name: "namespace selector",
+			src:  kubegoodiesv1.PropagationSource{NamespaceSelector: &metav1.LabelSelector{}},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isMultiNamespaceSource(&tt.src); got != tt.want {
+				t.Errorf("isMultiNamespaceSource() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveSourceNamespaces(t *testing.T) {
+	namespace := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	r := &ConfigMapPropagationReconciler{Client: newTestClient(
+		namespace("c", map[string]string{"team": "x"}),
+		namespace("b", map[string]string{"team": "x"}),
+		namespace("a", map[string]string{"team": "x"}),
+		namespace("d", nil),
+	)}
+
+	tests := []struct {
+		name              string
+		src               kubegoodiesv1.PropagationSource
+		want              []string
+		wantAllNamespaces bool
+	}{
+		{
+			name: "listed",
+			src:  kubegoodiesv1.PropagationSource{Namespace: "d", Namespaces: []string{"d", "b"}},
+			want: []string{"d", "b"},
+		},
+		{
+			name: "listed then selected",
+			src: kubegoodiesv1.PropagationSource{
+				Namespace:         "d",
+				Namespaces:        []string{"b"},
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "x"}},
+			},
+			want: []string{"d", "b", "a", "c"},
+		},
+		{
+			name:              "all namespaces",
+			src:               kubegoodiesv1.PropagationSource{Namespace: kubegoodiesv1.AllNamespaces},
+			wantAllNamespaces: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, allNamespaces, err := r.resolveSourceNamespaces(context.Background(), &tt.src)
+			if err != nil {
+				t.Fatalf("resolveSourceNamespaces() error = %v", err)
+			}
+			if allNamespaces != tt.wantAllNamespaces {
+				t.Errorf("resolveSourceNamespaces() allNamespaces = %v, want %v", allNamespaces, tt.wantAllNamespaces)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolveSourceNamespaces() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectSources(t *testing.T) {
+	configMap := func(namespace, name string, labels map[string]string, target bool) *corev1.ConfigMap {
+		cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels}}
+		if target {
+			cm.Annotations = map[string]string{}
+			configmappropagation.SetPropagationAnnotation(cm.Annotations, "other", name)
+		}
+		return cm
+	}
+	selected := map[string]string{"propagate": "true"}
+	r := &ConfigMapPropagationReconciler{Client: newTestClient(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "b", Labels: map[string]string{"team": "x"}}},
+		configMap("a", "config", selected, false),
+		configMap("a", "other", nil, false),
+		configMap("b", "config", nil, false),
+		configMap("b", "selected", selected, false),
+		configMap("c", "config", selected, true),
+		configMap("c", "selected", selected, false),
+	)}
+
+	nn := func(namespace, name string) types.NamespacedName {
+		return types.NamespacedName{Namespace: namespace, Name: name}
+	}
+
+	tests := []struct {
+		name string
+		src  kubegoodiesv1.PropagationSource
+		want []types.NamespacedName
+	}{
+		{
+			name: "names, including missing ones",
+			src:  kubegoodiesv1.PropagationSource{Namespaces: []string{"b", "a"}, Names: []string{"missing", "config"}},
+			want: []types.NamespacedName{nn("a", "config"), nn("a", "missing"), nn("b", "config"), nn("b", "missing")},
+		},
+		{
+			name: "names in all namespaces skip targets",
+			src:  kubegoodiesv1.PropagationSource{Namespace: kubegoodiesv1.AllNamespaces, Names: []string{"config", "missing"}},
+			want: []types.NamespacedName{nn("a", "config"), nn("b", "config")},
+		},
+		{
+			name: "object selector in selected namespaces",
+			src: kubegoodiesv1.PropagationSource{
+				Namespace:         "a",
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "x"}},
+				ObjectSelector:    &metav1.LabelSelector{MatchLabels: selected},
+			},
+			want: []types.NamespacedName{nn("a", "config"), nn("b", "selected")},
+		},
+		{
+			name: "object selector in all namespaces skips targets",
+			src: kubegoodiesv1.PropagationSource{
+				Namespace:      kubegoodiesv1.AllNamespaces,
+				ObjectSelector: &metav1.LabelSelector{MatchLabels: selected},
+			},
+			want: []types.NamespacedName{nn("a", "config"), nn("b", "selected"), nn("c", "selected")},
+		},
+		{
+			name: "object selector with match expressions",
+			src: kubegoodiesv1.PropagationSource{
+				Namespace: "a",
+				ObjectSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "propagate", Operator: metav1.LabelSelectorOpDoesNotExist},
+				}},
+			},
+			want: []types.NamespacedName{nn("a", "other")},
+		},
+		{
+			name: "namespace selector with match expressions",
+			src: kubegoodiesv1.PropagationSource{
+				NamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "team", Operator: metav1.LabelSelectorOpIn, Values: []string{"x", "y"}},
+				}},
+				ObjectSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "propagate", Operator: metav1.LabelSelectorOpIn, Values: []string{"true"}},
+				}},
+			},
+			want: []types.NamespacedName{nn("b", "selected")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &kubegoodiesv1.ConfigMapPropagation{Spec: kubegoodiesv1.ConfigMapPropagationSpec{Source: tt.src}}
+			got, err := r.collectSources(context.Background(), pr)
+			if err != nil {
+				t.Fatalf("collectSources() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("collectSources() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailureReason(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: &configmappropagation.ConflictError{}, want: "TargetConflict"},
+		{err: &configmappropagation.FieldManagerConflictError{}, want: "FieldManagerConflict"},
+		{err: &configmappropagation.KeyMappingCollisionError{}, want: "KeyMappingCollision"},
+		{err: &configmappropagation.KeyCollisionError{}, want: "KeyCollision"},
+		{err: fmt.Errorf("wrapped: %w", &configmappropagation.TemplateRenderError{Err: errors.New("boom")}), want: "TemplateRenderFailed"},
+		{err: errors.New("boom"), want: "PropagationFailed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := failureReason(tt.err); got != tt.want {
+				t.Errorf("failureReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// slowClient delays getting the configmaps by the number in their name, and tracks the gets in flight.
+type slowClient struct {
+	client.Client
+
+	lock        sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (c *slowClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	c.lock.Lock()
+	c.inFlight++
+	if c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		c.inFlight--
+		c.lock.Unlock()
+	}()
+
+	if delay, err := strconv.Atoi(strings.TrimPrefix(key.Name, "cm-")); err == nil {
+		time.Sleep(time.Duration(delay) * time.Millisecond)
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func TestExecuteAll(t *testing.T) {
+	const count = 8
+
+	var objs []client.Object
+	var executionReqs []configmappropagation.Request
+	for i := 0; i < count; i++ {
+		// the first requests take the longest, so they complete last
+		name := fmt.Sprintf("cm-%d", 10*(count-i))
+		objs = append(objs,
+			&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "src", Name: name}},
+			// the unmanaged targets fail the requests with a conflict naming the target
+			&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "target", Name: name}},
+		)
+		executionReqs = append(executionReqs, configmappropagation.Request{
+			SourceNamespace: "src",
+			SourceName:      name,
+			TargetNamespace: "target",
+			TargetName:      name,
+			ConflictPolicy:  kubegoodiesv1.ConflictPolicyFail,
+		})
+	}
+
+	for _, maxConcurrency := range []int{1, 3, count} {
+		t.Run(strconv.Itoa(maxConcurrency), func(t *testing.T) {
+			cl := &slowClient{Client: newTestClient(objs...)}
+			r := &ConfigMapPropagationReconciler{Client: cl}
+
+			outcomes := r.executeAll(context.Background(), executionReqs, maxConcurrency)
+			if len(outcomes) != count {
+				t.Fatalf("expected %d outcomes, got %d", count, len(outcomes))
+			}
+			for i, outcome := range outcomes {
+				var conflictErr *configmappropagation.ConflictError
+				if !errors.As(outcome.err, &conflictErr) {
+					t.Fatalf("expected a conflict for request %d, got %v", i, outcome.err)
+				}
+				if conflictErr.Target.Name != executionReqs[i].TargetName {
+					t.Errorf("outcome %d is of target %s, expected %s", i, conflictErr.Target.Name, executionReqs[i].TargetName)
+				}
+			}
+			if cl.maxInFlight > maxConcurrency {
+				t.Errorf("expected at most %d requests at a time, got %d", maxConcurrency, cl.maxInFlight)
+			}
+		})
+	}
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	tests := []struct {
+		name           string
+		flag           int
+		maxConcurrency int32
+		want           int
+	}{
+		{name: "flag", flag: 10, want: 10},
+		{name: "lower than the flag", flag: 10, maxConcurrency: 2, want: 2},
+		{name: "capped by the flag", flag: 10, maxConcurrency: 1000, want: 10},
+		{name: "no flag", maxConcurrency: 5, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ConfigMapPropagationReconciler{MaxConcurrentPropagations: tt.flag}
+			pr := &kubegoodiesv1.ConfigMapPropagation{Spec: kubegoodiesv1.ConfigMapPropagationSpec{MaxConcurrency: tt.maxConcurrency}}
+			if got := r.maxConcurrency(pr); got != tt.want {
+				t.Errorf("maxConcurrency() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckTargetNamespace(t *testing.T) {
+	terminating := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "terminating"},
+		Status:     corev1.NamespaceStatus{Phase: corev1.NamespaceTerminating},
+	}
+
+	tests := []struct {
+		name       string
+		policy     kubegoodiesv1.MissingNamespacePolicy
+		namespace  string
+		want       namespaceCheck
+		wantExists bool
+	}{
+		{
+			name:       "existing",
+			policy:     kubegoodiesv1.MissingNamespacePolicyWait,
+			namespace:  "existing",
+			want:       namespaceCheck{ready: true},
+			wantExists: true,
+		},
+		{
+			name:      "missing, wait",
+			policy:    kubegoodiesv1.MissingNamespacePolicyWait,
+			namespace: "missing",
+			want:      namespaceCheck{status: metav1.ConditionUnknown, reason: "WaitingForTargetNamespace"},
+		},
+		{
+			name:      "missing, skip",
+			policy:    kubegoodiesv1.MissingNamespacePolicySkip,
+			namespace: "missing",
+			want:      namespaceCheck{status: metav1.ConditionFalse, reason: "TargetNamespaceNotFound"},
+		},
+		{
+			name:       "missing, create",
+			policy:     kubegoodiesv1.MissingNamespacePolicyCreate,
+			namespace:  "missing",
+			want:       namespaceCheck{ready: true},
+			wantExists: true,
+		},
+		{
+			name:       "terminating, create",
+			policy:     kubegoodiesv1.MissingNamespacePolicyCreate,
+			namespace:  "terminating",
+			want:       namespaceCheck{status: metav1.ConditionUnknown, reason: "TargetNamespaceTerminating"},
+			wantExists: true,
+		},
+		{
+			name:       "terminating, skip",
+			policy:     kubegoodiesv1.MissingNamespacePolicySkip,
+			namespace:  "terminating",
+			want:       namespaceCheck{status: metav1.ConditionFalse, reason: "TargetNamespaceTerminating"},
+			wantExists: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ConfigMapPropagationReconciler{Client: newTestClient(
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "existing"}},
+				terminating.DeepCopy(),
+			)}
+
+			got, err := r.checkTargetNamespace(context.Background(), tt.policy, tt.namespace)
+			if err != nil {
+				t.Fatalf("checkTargetNamespace() error = %v", err)
+			}
+			// the messages are for humans
+			got.message = ""
+			if got != tt.want {
+				t.Errorf("checkTargetNamespace() = %+v, want %+v", got, tt.want)
+			}
+
+			var ns corev1.Namespace
+			err = r.Get(context.Background(), types.NamespacedName{Name: tt.namespace}, &ns)
+			if exists := err == nil; exists != tt.wantExists {
+				t.Errorf("namespace exists = %v, want %v", exists, tt.wantExists)
+			}
+		})
+	}
+}
+
+// newTestPropagation returns a propagation of the configmaps a and b in the namespace src to the namespaces t1 and t2,
+// with the policies the API server would default.
+func newTestPropagation() *kubegoodiesv1.ConfigMapPropagation {
+	return &kubegoodiesv1.ConfigMapPropagation{
+		ObjectMeta: metav1.ObjectMeta{Name: "pr", UID: "pr-uid", Generation: 1},
+		Spec: kubegoodiesv1.ConfigMapPropagationSpec{
+			Source:                 kubegoodiesv1.PropagationSource{Namespace: "src", Names: []string{"a", "b"}},
+			Target:                 kubegoodiesv1.PropagationTarget{Namespaces: []string{"t1", "t2"}},
+			MissingNamespacePolicy: kubegoodiesv1.MissingNamespacePolicyWait,
+			DeletionPolicy:         kubegoodiesv1.DeletionPolicyDelete,
+			ConflictPolicy:         kubegoodiesv1.ConflictPolicyFail,
+			DriftPolicy:            kubegoodiesv1.DriftPolicyRevert,
+		},
+	}
+}
+
+// newTestObjects returns the namespaces and the source configmaps of newTestPropagation.
+func newTestObjects() []client.Object {
+	return []client.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "src"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "t1"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "t2"}},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "src", Name: "a", Labels: map[string]string{"app": "foo"}},
+			Data:       map[string]string{"key": "a"},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "src", Name: "b", Labels: map[string]string{"app": "foo"}},
+			Data:       map[string]string{"key": "b"},
+		},
+	}
+}
+
+// reconcilePropagation reconciles the propagation and returns it as persisted afterwards.
+func reconcilePropagation(t *testing.T, r *ConfigMapPropagationReconciler, name string) (*kubegoodiesv1.ConfigMapPropagation, error) {
+	t.Helper()
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: name}})
+
+	var pr kubegoodiesv1.ConfigMapPropagation
+	if getErr := r.Get(context.Background(), types.NamespacedName{Name: name}, &pr); getErr != nil {
+		t.Fatalf("unable to get ConfigMapPropagation: %v", getErr)
+	}
+	return &pr, err
+}
+
+// targetConfigMaps returns the "namespace/name" of the configmaps in the namespaces t1 and t2, sorted.
+func targetConfigMaps(t *testing.T, cl client.Client) []string {
+	t.Helper()
+
+	var targets []string
+	for _, ns := range []string{"t1", "t2"} {
+		var cmList corev1.ConfigMapList
+		if err := cl.List(context.Background(), &cmList, client.InNamespace(ns)); err != nil {
+			t.Fatalf("unable to list ConfigMaps: %v", err)
+		}
+		for _, cm := range cmList.Items {
+			targets = append(targets, cm.Namespace+"/"+cm.Name)
+		}
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+func TestPruneStaleTargets(t *testing.T) {
+	// claimed is propagated by another propagation, unmanaged by no propagation at all
+	claimed := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "t1", Name: "claimed", Annotations: map[string]string{}}}
+	configmappropagation.SetPropagationAnnotation(claimed.Annotations, "src", "claimed")
+	configmappropagation.SetOwnerAnnotation(claimed.Annotations, "other", "other-uid")
+	unmanaged := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "t1", Name: "unmanaged"}}
+
+	tests := []struct {
+		name        string
+		spec        func(pr *kubegoodiesv1.ConfigMapPropagation)
+		change      func(ctx context.Context, cl client.Client, pr *kubegoodiesv1.ConfigMapPropagation) error
+		wantTargets []string
+		wantPruned  int32
+	}{
+		{
+			name: "name removed from the source",
+			change: func(ctx context.Context, cl client.Client, pr *kubegoodiesv1.ConfigMapPropagation) error {
+				pr.Spec.Source.Names = []string{"a"}
+				return cl.Update(ctx, pr)
+			},
+			wantTargets: []string{"t1/a", "t1/claimed", "t1/unmanaged", "t2/a"},
+			wantPruned:  2,
+		},
+		{
+			name: "namespace removed from the target",
+			change: func(ctx context.Context, cl client.Client, pr *kubegoodiesv1.ConfigMapPropagation) error {
+				pr.Spec.Target.Namespaces = []string{"t1"}
+				return cl.Update(ctx, pr)
+			},
+			wantTargets: []string{"t1/a", "t1/b", "t1/claimed", "t1/unmanaged"},
+			wantPruned:  2,
+		},
+		{
+			name: "namespace excluded from the target",
+			change: func(ctx context.Context, cl client.Client, pr *kubegoodiesv1.ConfigMapPropagation) error {
+				pr.Spec.Target.ExcludeNamespaces = []string{"t2"}
+				return cl.Update(ctx, pr)
+			},
+			wantTargets: []string{"t1/a", "t1/b", "t1/claimed", "t1/unmanaged"},
+			wantPruned:  2,
+		},
+		{
+			name: "source stops matching the selector",
+			spec: func(pr *kubegoodiesv1.ConfigMapPropagation) {
+				pr.Spec.Source.Names = nil
+				pr.Spec.Source.ObjectSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "foo"}}
+			},
+			change: func(ctx context.Context, cl client.Client, _ *kubegoodiesv1.ConfigMapPropagation) error {
+				var src corev1.ConfigMap
+				if err := cl.Get(ctx, types.NamespacedName{Namespace: "src", Name: "b"}, &src); err != nil {
+					return err
+				}
+				src.Labels = nil
+				return cl.Update(ctx, &src)
+			},
+			wantTargets: []string{"t1/a", "t1/claimed", "t1/unmanaged", "t2/a"},
+			wantPruned:  2,
+		},
+		{
+			name: "claimed and conflicting targets are kept",
+			change: func(ctx context.Context, cl client.Client, pr *kubegoodiesv1.ConfigMapPropagation) error {
+				pr.Status.PropagationStatus = append(pr.Status.PropagationStatus,
+					kubegoodiesv1.PropagationStatus{SourceNamespace: "src", SourceName: "claimed", TargetNamespace: "t1", TargetName: "claimed", Status: metav1.ConditionFalse, Reason: "TargetClaimed"},
+					kubegoodiesv1.PropagationStatus{SourceNamespace: "src", SourceName: "unmanaged", TargetNamespace: "t1", TargetName: "unmanaged", Status: metav1.ConditionFalse, Reason: "TargetConflict"},
+				)
+				return cl.Status().Update(ctx, pr)
+			},
+			wantTargets: []string{"t1/a", "t1/b", "t1/claimed", "t1/unmanaged", "t2/a", "t2/b"},
+			wantPruned:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			pr := newTestPropagation()
+			if tt.spec != nil {
+				tt.spec(pr)
+			}
+			cl := newTestClient(append(newTestObjects(), pr, claimed.DeepCopy(), unmanaged.DeepCopy())...)
+			r := &ConfigMapPropagationReconciler{Client: cl}
+
+			pr, err := reconcilePropagation(t, r, "pr")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got, want := targetConfigMaps(t, cl), []string{"t1/a", "t1/b", "t1/claimed", "t1/unmanaged", "t2/a", "t2/b"}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("targets before the change = %v, want %v", got, want)
+			}
+
+			if err := tt.change(ctx, cl, pr); err != nil {
+				t.Fatalf("unable to change: %v", err)
+			}
+
+			pr, err = reconcilePropagation(t, r, "pr")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := targetConfigMaps(t, cl); !reflect.DeepEqual(got, tt.wantTargets) {
+				t.Errorf("targets = %v, want %v", got, tt.wantTargets)
+			}
+			if pr.Status.PrunedTargets != tt.wantPruned {
+				t.Errorf("prunedTargets = %d, want %d", pr.Status.PrunedTargets, tt.wantPruned)
+			}
+		})
+	}
+}
+
+// failingDeleteClient fails deleting configmaps.
+type failingDeleteClient struct {
+	client.Client
+}
+
+func (c failingDeleteClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if _, ok := obj.(*corev1.ConfigMap); ok {
+		return errors.New("delete failed")
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func TestFinalize(t *testing.T) {
+	// foreign is propagated from the same source by a deleted propagation with the same name
+	foreign := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "t1",
+			Name:            "foreign",
+			Annotations:     map[string]string{},
+			OwnerReferences: []metav1.OwnerReference{configmappropagation.NewOwnerReference("pr", "old-uid")},
+		},
+	}
+	configmappropagation.SetPropagationAnnotation(foreign.Annotations, "src", "a")
+	configmappropagation.SetOwnerAnnotation(foreign.Annotations, "pr", "old-uid")
+
+	tests := []struct {
+		name          string
+		policy        kubegoodiesv1.DeletionPolicy
+		failDeletes   bool
+		wantTargets   []string
+		wantOwned     bool
+		wantErr       bool
+		wantFinalizer bool
+	}{
+		{
+			name:        "delete",
+			policy:      kubegoodiesv1.DeletionPolicyDelete,
+			wantTargets: []string{"t1/foreign"},
+		},
+		{
+			name:        "orphan",
+			policy:      kubegoodiesv1.DeletionPolicyOrphan,
+			wantTargets: []string{"t1/a", "t1/b", "t1/foreign", "t2/a", "t2/b"},
+		},
+		{
+			name:          "failed deletion",
+			policy:        kubegoodiesv1.DeletionPolicyDelete,
+			failDeletes:   true,
+			wantTargets:   []string{"t1/a", "t1/b", "t1/foreign", "t2/a", "t2/b"},
+			wantOwned:     true,
+			wantErr:       true,
+			wantFinalizer: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			pr := newTestPropagation()
+			pr.Spec.DeletionPolicy = tt.policy
+			cl := newTestClient(append(newTestObjects(), pr, foreign.DeepCopy())...)
+			r := &ConfigMapPropagationReconciler{Client: cl}
+
+			pr, err := reconcilePropagation(t, r, "pr")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !controllerutil.ContainsFinalizer(pr, propagationFinalizer) {
+				t.Fatalf("expected the finalizer to be added")
+			}
+
+			if tt.failDeletes {
+				r.Client = failingDeleteClient{Client: cl}
+			}
+			if err := r.finalize(ctx, pr); (err != nil) != tt.wantErr {
+				t.Fatalf("finalize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got := targetConfigMaps(t, cl); !reflect.DeepEqual(got, tt.wantTargets) {
+				t.Errorf("targets = %v, want %v", got, tt.wantTargets)
+			}
+
+			var target corev1.ConfigMap
+			if err := cl.Get(ctx, types.NamespacedName{Namespace: "t1", Name: "a"}, &target); err == nil {
+				owned := metav1.GetControllerOf(&target) != nil || configmappropagation.GetOwnerAnnotation(target.Annotations) != ""
+				if owned != tt.wantOwned {
+					t.Errorf("target owned = %v, want %v: %v", owned, tt.wantOwned, target.ObjectMeta)
+				}
+			}
+
+			var got kubegoodiesv1.ConfigMapPropagation
+			if err := cl.Get(ctx, types.NamespacedName{Name: "pr"}, &got); err != nil {
+				t.Fatalf("unable to get ConfigMapPropagation: %v", err)
+			}
+			if hasFinalizer := controllerutil.ContainsFinalizer(&got, propagationFinalizer); hasFinalizer != tt.wantFinalizer {
+				t.Errorf("finalizer = %v, want %v", hasFinalizer, tt.wantFinalizer)
+			}
+		})
+	}
+}
+
+func TestReconcileMissingSource(t *testing.T) {
+	pr := newTestPropagation()
+	pr.Spec.Source.Names = []string{"a", "b", "missing"}
+	// the target of the missing source was propagated before the source was deleted
+	stale := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "t1",
+			Name:            "missing",
+			Annotations:     map[string]string{},
+			OwnerReferences: []metav1.OwnerReference{configmappropagation.NewOwnerReference(pr.Name, pr.UID)},
+		},
+	}
+	configmappropagation.SetPropagationAnnotation(stale.Annotations, "src", "missing")
+	configmappropagation.SetOwnerAnnotation(stale.Annotations, pr.Name, pr.UID)
+
+	cl := newTestClient(append(newTestObjects(), pr, stale)...)
+	recorder := record.NewFakeRecorder(100)
+	r := &ConfigMapPropagationReconciler{Client: cl, Recorder: recorder}
+
+	pr, err := reconcilePropagation(t, r, "pr")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, want := targetConfigMaps(t, cl), []string{"t1/a", "t1/b", "t2/a", "t2/b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("targets = %v, want %v", got, want)
+	}
+	if got := testutil.ToFloat64(managedTargets.WithLabelValues("pr")); got != 4 {
+		t.Errorf("managed targets = %v, want 4", got)
+	}
+
+	missing := 0
+	for _, itemStatus := range pr.Status.PropagationStatus {
+		if itemStatus.SourceName != "missing" {
+			continue
+		}
+		missing++
+		if itemStatus.Status != metav1.ConditionFalse || itemStatus.Reason != "SourceNotFound" {
+			t.Errorf("unexpected status of the target of the missing source: %+v", itemStatus)
+		}
+	}
+	if missing != 2 {
+		t.Errorf("expected a status for the 2 targets of the missing source, got %d", missing)
+	}
+
+	deleted := false
+	for len(recorder.Events) > 0 {
+		if strings.HasPrefix(<-recorder.Events, "Normal TargetDeleted") {
+			deleted = true
+		}
+	}
+	if !deleted {
+		t.Errorf("expected a TargetDeleted event")
+	}
+
+}
+
+func TestValidateSpec(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(spec *kubegoodiesv1.ConfigMapPropagationSpec)
+		want   string
+	}{
+		{
+			name:   "valid",
+			mutate: func(spec *kubegoodiesv1.ConfigMapPropagationSpec) {},
+		},
+		{
+			name: "source namespaces only",
+			mutate: func(spec *kubegoodiesv1.ConfigMapPropagationSpec) {
+				spec.Source.Namespace = ""
+				spec.Source.Namespaces = []string{"src"}
+			},
+		},
+		{
+			name: "source namespace selector only",
+			mutate: func(spec *kubegoodiesv1.ConfigMapPropagationSpec) {
+				spec.Source.Namespace = ""
+				spec.Source.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+			},
+		},
+		{
+			name: "no source namespace",
+			mutate: func(spec *kubegoodiesv1.ConfigMapPropagationSpec) {
+				spec.Source.Namespace = ""
+			},
+			want: "InvalidSource",
+		},
+		{
+			name: "invalid object selector",
+			mutate: func(spec *kubegoodiesv1.ConfigMapPropagationSpec) {
+				spec.Source.ObjectSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "app", Operator: metav1.LabelSelectorOpIn},
+				}}
+			},
+			want: "InvalidObjectSelector",
+		},
+		{
+			name: "invalid target namespace selector",
+			mutate: func(spec *kubegoodiesv1.ConfigMapPropagationSpec) {
+				spec.Target.NamespaceSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "team", Operator: "Unknown"},
+				}}
+			},
+			want: "InvalidNamespaceSelector",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := newTestPropagation().Spec
+			tt.mutate(&spec)
+
+			if reason, message := validateSpec(&spec); reason != tt.want {
+				t.Errorf("validateSpec() reason = %q (%s), want %q", reason, message, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileInvalidSpec(t *testing.T) {
+	pr := newTestPropagation()
+	pr.Spec.Source.Names = nil
+	pr.Spec.Source.ObjectSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+		{Key: "app", Operator: metav1.LabelSelectorOpIn},
+	}}
+	r := &ConfigMapPropagationReconciler{Client: newTestClient(append(newTestObjects(), pr)...), MaxConcurrentPropagations: 1}
+
+	got, err := reconcilePropagation(t, r, "pr")
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	specValid := meta.FindStatusCondition(got.Status.Conditions, kubegoodiesv1.ConfigMapPropagationConditionTypeSpecValid)
+	if specValid == nil || specValid.Status != metav1.ConditionFalse || specValid.Reason != "InvalidObjectSelector" {
+		t.Errorf("unexpected SpecValid condition %+v", specValid)
+	}
+	ready := meta.FindStatusCondition(got.Status.Conditions, kubegoodiesv1.ConfigMapPropagationConditionTypeReady)
+	if ready == nil || ready.Status != metav1.ConditionFalse || ready.Reason != "InvalidSpec" {
+		t.Errorf("unexpected Ready condition %+v", ready)
+	}
+	// nothing is propagated from an invalid spec
+	if targets := targetConfigMaps(t, r.Client); len(targets) != 0 {
+		t.Errorf("expected no targets, got %v", targets)
+	}
+}
+
+func TestResolveTargetNamespaces(t *testing.T) {
+	team := map[string]string{"team": "a"}
+	objs := []client.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "t1"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "t2", Labels: team}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "t3", Labels: team}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "t4", Labels: map[string]string{"team": "b"}}},
+	}
+	listed := func(name string) kubegoodiesv1.TargetNamespaceStatus {
+		return kubegoodiesv1.TargetNamespaceStatus{Name: name, Reason: kubegoodiesv1.TargetNamespaceReasonListed}
+	}
+	selected := func(name string) kubegoodiesv1.TargetNamespaceStatus {
+		return kubegoodiesv1.TargetNamespaceStatus{Name: name, Reason: kubegoodiesv1.TargetNamespaceReasonSelected}
+	}
+
+	tests := []struct {
+		name      string
+		target    kubegoodiesv1.PropagationTarget
+		overrides []kubegoodiesv1.TargetOverride
+		want      []kubegoodiesv1.TargetNamespaceStatus
+	}{
+		{
+			name:   "listed",
+			target: kubegoodiesv1.PropagationTarget{Namespaces: []string{"t2", "t1"}},
+			want:   []kubegoodiesv1.TargetNamespaceStatus{listed("t2"), listed("t1")},
+		},
+		{
+			name:   "selected",
+			target: kubegoodiesv1.PropagationTarget{NamespaceSelector: &metav1.LabelSelector{MatchLabels: team}},
+			want:   []kubegoodiesv1.TargetNamespaceStatus{selected("t2"), selected("t3")},
+		},
+		{
+			name: "listed and selected are merged, listed first",
+			target: kubegoodiesv1.PropagationTarget{
+				Namespaces:        []string{"t3", "t1", "t1"},
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: team},
+			},
+			want: []kubegoodiesv1.TargetNamespaceStatus{listed("t3"), listed("t1"), selected("t2")},
+		},
+		{
+			name: "excluded",
+			target: kubegoodiesv1.PropagationTarget{
+				Namespaces:        []string{"t1", "t2"},
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: team},
+				ExcludeNamespaces: []string{"t1", "t3"},
+			},
+			want: []kubegoodiesv1.TargetNamespaceStatus{listed("t2")},
+		},
+		{
+			name:   "namespaces of the overrides",
+			target: kubegoodiesv1.PropagationTarget{Namespaces: []string{"t1"}},
+			overrides: []kubegoodiesv1.TargetOverride{
+				{Name: "listed", Namespace: "t1"},
+				{Name: "selected", NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "b"}}},
+			},
+			want: []kubegoodiesv1.TargetNamespaceStatus{listed("t1"), selected("t4")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ConfigMapPropagationReconciler{Client: newTestClient(objs...)}
+			pr := &kubegoodiesv1.ConfigMapPropagation{Spec: kubegoodiesv1.ConfigMapPropagationSpec{Target: tt.target, Targets: tt.overrides}}
+
+			got, err := r.resolveTargetNamespaces(context.Background(), pr)
+			if err != nil {
+				t.Fatalf("resolveTargetNamespaces() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolveTargetNamespaces() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileTargetNamespacesStatus(t *testing.T) {
+	objs := append(newTestObjects(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "t3", Labels: map[string]string{"team": "a"}}})
+	pr := newTestPropagation()
+	pr.Spec.Target.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+	pr.Spec.Target.ExcludeNamespaces = []string{"t2"}
+	r := &ConfigMapPropagationReconciler{Client: newTestClient(append(objs, pr)...), MaxConcurrentPropagations: 1}
+
+	got, err := reconcilePropagation(t, r, "pr")
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	want := []kubegoodiesv1.TargetNamespaceStatus{
+		{Name: "t1", Reason: kubegoodiesv1.TargetNamespaceReasonListed},
+		{Name: "t3", Reason: kubegoodiesv1.TargetNamespaceReasonSelected},
+	}
+	if !reflect.DeepEqual(got.Status.TargetNamespaces, want) {
+		t.Errorf("status.targetNamespaces = %v, want %v", got.Status.TargetNamespaces, want)
+	}
+}
+
+func TestReconcilePersistsStatusOnFailure(t *testing.T) {
+	unmanaged := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "t1", Name: "a"},
+		Data:       map[string]string{"key": "unmanaged"},
+	}
+	r := &ConfigMapPropagationReconciler{Client: newTestClient(append(newTestObjects(), newTestPropagation(), unmanaged)...), MaxConcurrentPropagations: 1}
+
+	got, err := reconcilePropagation(t, r, "pr")
+	if err == nil {
+		t.Fatalf("expected the failed propagation to be returned as an error")
+	}
+
+	if got.Status.ObservedGeneration != 1 {
+		t.Errorf("status.observedGeneration = %d, want 1", got.Status.ObservedGeneration)
+	}
+	ready := meta.FindStatusCondition(got.Status.Conditions, kubegoodiesv1.ConfigMapPropagationConditionTypeReady)
+	if ready == nil || ready.Status != metav1.ConditionFalse || ready.Reason != "Degraded" || ready.ObservedGeneration != 1 {
+		t.Errorf("unexpected Ready condition %+v", ready)
+	}
+
+	statuses := map[string]string{}
+	for _, s := range got.Status.PropagationStatus {
+		statuses[s.TargetNamespace+"/"+s.TargetName] = string(s.Status) + "/" + s.Reason
+	}
+	want := map[string]string{
+		"t1/a": "False/TargetConflict",
+		"t1/b": "True/PropagationSucceeded",
+		"t2/a": "True/PropagationSucceeded",
+		"t2/b": "True/PropagationSucceeded",
+	}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("status.propagationStatus = %v, want %v", statuses, want)
+	}
+
+	// the other targets are propagated and the conflicting one is left alone
+	var cm corev1.ConfigMap
+	if err := r.Get(context.Background(), types.NamespacedName{Namespace: "t1", Name: "a"}, &cm); err != nil || cm.Data["key"] != "unmanaged" {
+		t.Errorf("expected the unmanaged configmap to be kept, got %v, %v", cm.Data, err)
+	}
+	if targets := targetConfigMaps(t, r.Client); !reflect.DeepEqual(targets, []string{"t1/a", "t1/b", "t2/a", "t2/b"}) {
+		t.Errorf("unexpected targets %v", targets)
+	}
+}
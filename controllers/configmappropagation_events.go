@@ -0,0 +1,119 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubegoodiesv1 "github.com/aliok/kubegoodies/api/v1"
+)
+
+const (
+	// maxTargetEventsPerReconcile is the number of events emitted on target configmaps in a reconciliation.
+	// The rest is only counted in the aggregated events on the ConfigMapPropagation.
+	maxTargetEventsPerReconcile = 10
+
+	// maxAggregatedEventTargets is the number of targets listed in the message of an aggregated event.
+	maxAggregatedEventTargets = 5
+)
+
+type aggregatedEvent struct {
+	eventType string
+	reason    string
+	targets   []string
+	count     int
+}
+
+// eventAggregator collects the events of a reconciliation, so that a propagation to many namespaces
+// results in a single event per reason on the ConfigMapPropagation instead of one event per target.
+type eventAggregator struct {
+	recorder     record.EventRecorder
+	reader       client.Reader
+	pr           *kubegoodiesv1.ConfigMapPropagation
+	events       []*aggregatedEvent
+	targetEvents int
+}
+
+// newEventAggregator returns an event aggregator that reads the target configmaps from the given reader,
+// so that their events refer to their UID.
+func newEventAggregator(recorder record.EventRecorder, reader client.Reader, pr *kubegoodiesv1.ConfigMapPropagation) *eventAggregator {
+	return &eventAggregator{recorder: recorder, reader: reader, pr: pr}
+}
+
+// record counts an event about a target configmap and emits it on the target while the budget allows.
+func (a *eventAggregator) record(ctx context.Context, eventType, reason string, target types.NamespacedName, message string) {
+	if a.recorder == nil {
+		return
+	}
+
+	var event *aggregatedEvent
+	for _, e := range a.events {
+		if e.reason == reason {
+			event = e
+			break
+		}
+	}
+	if event == nil {
+		event = &aggregatedEvent{eventType: eventType, reason: reason}
+		a.events = append(a.events, event)
+	}
+	event.count++
+	if len(event.targets) < maxAggregatedEventTargets {
+		event.targets = append(event.targets, target.String())
+	}
+
+	if a.targetEvents < maxTargetEventsPerReconcile {
+		a.targetEvents++
+		a.recorder.Event(a.targetObject(ctx, target), eventType, reason, message)
+	}
+}
+
+// targetObject returns the target configmap to emit an event on.
+// Events are listed for an object by its UID, a configmap that can't be read, e.g. because it is deleted,
+// only has its name.
+func (a *eventAggregator) targetObject(ctx context.Context, target types.NamespacedName) *corev1.ConfigMap {
+	targetCm := &corev1.ConfigMap{}
+	if a.reader == nil || a.reader.Get(ctx, target, targetCm) != nil {
+		targetCm = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: target.Namespace, Name: target.Name}}
+	}
+	return targetCm
+}
+
+// flush emits one event per reason on the ConfigMapPropagation.
+func (a *eventAggregator) flush() {
+	if a.recorder == nil {
+		return
+	}
+
+	for _, event := range a.events {
+		targets := strings.Join(event.targets, ", ")
+		if more := event.count - len(event.targets); more > 0 {
+			targets = fmt.Sprintf("%s and %d more", targets, more)
+		}
+		a.recorder.Eventf(a.pr, event.eventType, event.reason, "%d target configmap(s): %s", event.count, targets)
+	}
+	a.events = nil
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubegoodiesv1 "github.com/aliok/kubegoodies/api/v1"
+)
+
+func TestEventAggregator(t *testing.T) {
+	recorder := record.NewFakeRecorder(100)
+	pr := &kubegoodiesv1.ConfigMapPropagation{ObjectMeta: metav1.ObjectMeta{Name: "pr"}}
+
+	events := newEventAggregator(recorder, nil, pr)
+	for i := 0; i < 500; i++ {
+		target := types.NamespacedName{Namespace: fmt.Sprintf("ns-%d", i), Name: "cm"}
+		events.record(context.Background(), corev1.EventTypeNormal, "TargetCreated", target, "Created from src/cm")
+	}
+	events.record(context.Background(), corev1.EventTypeWarning, "TargetConflict", types.NamespacedName{Namespace: "other", Name: "cm"}, "conflict")
+	events.flush()
+
+	if got := len(recorder.Events); got != maxTargetEventsPerReconcile+2 {
+		t.Fatalf("expected %d events, got %d", maxTargetEventsPerReconcile+2, got)
+	}
+
+	for i := 0; i < maxTargetEventsPerReconcile; i++ {
+		<-recorder.Events
+	}
+	want := []string{
+		"Normal TargetCreated 500 target configmap(s): ns-0/cm, ns-1/cm, ns-2/cm, ns-3/cm, ns-4/cm and 495 more",
+		"Warning TargetConflict 1 target configmap(s): other/cm",
+	}
+	for _, w := range want {
+		if got := <-recorder.Events; got != w {
+			t.Errorf("expected event %q, got %q", w, got)
+		}
+	}
+}
+
+func TestEventAggregatorWithoutRecorder(t *testing.T) {
+	events := newEventAggregator(nil, nil, &kubegoodiesv1.ConfigMapPropagation{})
+	events.record(context.Background(), corev1.EventTypeNormal, "TargetCreated", types.NamespacedName{Namespace: "ns", Name: "cm"}, "Created")
+	events.flush()
+}
+
+// objectRecorder records the objects the events are emitted on.
+type objectRecorder struct {
+	record.FakeRecorder
+	objects []runtime.Object
+}
+
+func (r *objectRecorder) Event(object runtime.Object, eventType, reason, message string) {
+	r.objects = append(r.objects, object)
+}
+
+func TestEventAggregatorTargetObject(t *testing.T) {
+	recorder := &objectRecorder{}
+	cl := newTestClient(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "cm", UID: "cm-uid"}})
+
+	events := newEventAggregator(recorder, cl, &kubegoodiesv1.ConfigMapPropagation{})
+	events.record(context.Background(), corev1.EventTypeNormal, "TargetUpdated", types.NamespacedName{Namespace: "ns", Name: "cm"}, "Updated")
+	events.record(context.Background(), corev1.EventTypeNormal, "TargetDeleted", types.NamespacedName{Namespace: "ns", Name: "deleted"}, "Deleted")
+
+	if len(recorder.objects) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(recorder.objects))
+	}
+	if cm := recorder.objects[0].(*corev1.ConfigMap); cm.Name != "cm" || cm.UID != "cm-uid" {
+		t.Errorf("expected the event on the target with its UID, got %s/%s with UID %q", cm.Namespace, cm.Name, cm.UID)
+	}
+	if cm := recorder.objects[1].(*corev1.ConfigMap); cm.Name != "deleted" || cm.UID != "" {
+		t.Errorf("expected the event on the missing target by its name, got %s/%s with UID %q", cm.Namespace, cm.Name, cm.UID)
+	}
+}
@@ -0,0 +1,331 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubegoodiesv1 "github.com/aliok/kubegoodies/api/v1"
+	"github.com/aliok/kubegoodies/pkg/configmappropagation"
+)
+
+const (
+	// sourceNameIndexKey indexes ConfigMapPropagations by the "namespace/name" of each source ConfigMap
+	// listed in spec.source.names. Sources that watch all namespaces or select their namespaces by labels
+	// are indexed with the "*" namespace.
+	sourceNameIndexKey = "spec.source.names"
+
+	// sourceSelectorIndexKey indexes ConfigMapPropagations that select their sources with an object selector
+	// by the namespaces the selector is applied in, using the same namespaces as sourceNameIndexKey.
+	sourceSelectorIndexKey = "spec.source.objectSelector"
+
+	// sourceNamespaceSelectorIndexKey indexes ConfigMapPropagations that select their source namespaces by labels.
+	sourceNamespaceSelectorIndexKey = "spec.source.namespaceSelector"
+
+	// targetNamespaceIndexKey indexes ConfigMapPropagations by each namespace listed in spec.target.namespaces.
+	targetNamespaceIndexKey = "spec.target.namespaces"
+
+	// targetNamespaceSelectorIndexKey indexes ConfigMapPropagations that select their target namespaces by labels.
+	targetNamespaceSelectorIndexKey = "spec.target.namespaceSelector"
+
+	// claimedTargetIndexKey indexes ConfigMapPropagations by the "namespace/name" of each target in their status.
+	claimedTargetIndexKey = "status.propagationStatus.target"
+
+	// targetOwnerIndexKey indexes ConfigMaps by the name of the ConfigMapPropagation controlling them.
+	targetOwnerIndexKey = ".metadata.controller"
+)
+
+// namespaceLifecyclePredicate passes namespace creations and deletions, and the updates that start
+// the termination of a namespace or change its labels or annotations, which templated values can refer to.
+var namespaceLifecyclePredicate = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		if e.ObjectOld.GetDeletionTimestamp().IsZero() != e.ObjectNew.GetDeletionTimestamp().IsZero() {
+			return true
+		}
+		return !labels.Equals(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels()) ||
+			!labels.Equals(e.ObjectOld.GetAnnotations(), e.ObjectNew.GetAnnotations())
+	},
+}
+
+// sourceIndexNamespaces returns the namespaces a propagation is indexed with for its sources.
+func sourceIndexNamespaces(src *kubegoodiesv1.PropagationSource) []string {
+	if src.Namespace == kubegoodiesv1.AllNamespaces || src.NamespaceSelector != nil {
+		// the selected namespaces change without the propagation changing
+		return []string{kubegoodiesv1.AllNamespaces}
+	}
+
+	var namespaces []string
+	if src.Namespace != "" {
+		namespaces = append(namespaces, src.Namespace)
+	}
+	return append(namespaces, src.Namespaces...)
+}
+
+func indexSourceNames(obj client.Object) []string {
+	pr := obj.(*kubegoodiesv1.ConfigMapPropagation)
+
+	var keys []string
+	for _, ns := range sourceIndexNamespaces(&pr.Spec.Source) {
+		for _, name := range pr.Spec.Source.Names {
+			keys = append(keys, types.NamespacedName{Namespace: ns, Name: name}.String())
+		}
+	}
+	return keys
+}
+
+func indexSourceSelector(obj client.Object) []string {
+	pr := obj.(*kubegoodiesv1.ConfigMapPropagation)
+
+	if pr.Spec.Source.ObjectSelector == nil {
+		return nil
+	}
+	return sourceIndexNamespaces(&pr.Spec.Source)
+}
+
+func indexSourceNamespaceSelector(obj client.Object) []string {
+	pr := obj.(*kubegoodiesv1.ConfigMapPropagation)
+
+	if pr.Spec.Source.NamespaceSelector == nil {
+		return nil
+	}
+	return []string{"true"}
+}
+
+func indexTargetNamespaces(obj client.Object) []string {
+	pr := obj.(*kubegoodiesv1.ConfigMapPropagation)
+
+	namespaces := append([]string{}, pr.Spec.Target.Namespaces...)
+	for _, override := range pr.Spec.Targets {
+		if override.Namespace != "" {
+			namespaces = append(namespaces, override.Namespace)
+		}
+	}
+	return namespaces
+}
+
+func indexTargetNamespaceSelector(obj client.Object) []string {
+	pr := obj.(*kubegoodiesv1.ConfigMapPropagation)
+
+	if len(targetNamespaceSelectors(pr)) == 0 {
+		return nil
+	}
+	return []string{"true"}
+}
+
+// targetNamespaceSelectors returns the selectors of the target namespaces, including the ones of the overrides.
+func targetNamespaceSelectors(pr *kubegoodiesv1.ConfigMapPropagation) []*metav1.LabelSelector {
+	var selectors []*metav1.LabelSelector
+	if pr.Spec.Target.NamespaceSelector != nil {
+		selectors = append(selectors, pr.Spec.Target.NamespaceSelector)
+	}
+	for _, override := range pr.Spec.Targets {
+		if override.NamespaceSelector != nil {
+			selectors = append(selectors, override.NamespaceSelector)
+		}
+	}
+	return selectors
+}
+
+func indexClaimedTargets(obj client.Object) []string {
+	pr := obj.(*kubegoodiesv1.ConfigMapPropagation)
+
+	var keys []string
+	for _, itemStatus := range pr.Status.PropagationStatus {
+		if itemStatus.TargetName == "" {
+			continue
+		}
+		keys = append(keys, types.NamespacedName{Namespace: itemStatus.TargetNamespace, Name: itemStatus.TargetName}.String())
+	}
+	return keys
+}
+
+func indexTargetOwner(obj client.Object) []string {
+	owner := metav1.GetControllerOf(obj)
+	if owner == nil || owner.APIVersion != kubegoodiesv1.GroupVersion.String() || owner.Kind != "ConfigMapPropagation" {
+		return nil
+	}
+	return []string{owner.Name}
+}
+
+// findPropagationsForSourceConfigMap maps a ConfigMap to the ConfigMapPropagations that use it as a source,
+// either by listing its name or by selecting it with their object selector.
+func (r *ConfigMapPropagationReconciler) findPropagationsForSourceConfigMap(obj client.Object) []reconcile.Request {
+	ctx := context.Background()
+	logger := log.FromContext(ctx).WithValues("configmap", client.ObjectKeyFromObject(obj))
+
+	// targets are never picked up as sources by the propagations that watch more than one namespace
+	isTarget := configmappropagation.IsTarget(obj.GetAnnotations())
+
+	var requests []reconcile.Request
+	seen := map[string]bool{}
+	enqueue := func(pr *kubegoodiesv1.ConfigMapPropagation) {
+		if seen[pr.Name] {
+			return
+		}
+		seen[pr.Name] = true
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: pr.Name}})
+	}
+
+	for _, ns := range []string{obj.GetNamespace(), kubegoodiesv1.AllNamespaces} {
+		if ns == kubegoodiesv1.AllNamespaces && isTarget {
+			continue
+		}
+
+		var byName kubegoodiesv1.ConfigMapPropagationList
+		if err := r.List(ctx, &byName, client.MatchingFields{sourceNameIndexKey: types.NamespacedName{Namespace: ns, Name: obj.GetName()}.String()}); err != nil {
+			logger.Error(err, "unable to list ConfigMapPropagations by source name")
+		}
+		for i := range byName.Items {
+			pr := &byName.Items[i]
+			if r.isSourceNamespace(ctx, &pr.Spec.Source, obj.GetNamespace()) {
+				enqueue(pr)
+			}
+		}
+
+		var bySelector kubegoodiesv1.ConfigMapPropagationList
+		if err := r.List(ctx, &bySelector, client.MatchingFields{sourceSelectorIndexKey: ns}); err != nil {
+			logger.Error(err, "unable to list ConfigMapPropagations by source selector")
+		}
+		for i := range bySelector.Items {
+			pr := &bySelector.Items[i]
+			if !r.isSourceNamespace(ctx, &pr.Spec.Source, obj.GetNamespace()) {
+				continue
+			}
+			selector, err := metav1.LabelSelectorAsSelector(pr.Spec.Source.ObjectSelector)
+			if err != nil {
+				// let the reconciler deal with the invalid selector
+				enqueue(pr)
+				continue
+			}
+			if selector.Matches(labels.Set(obj.GetLabels())) {
+				enqueue(pr)
+			}
+		}
+	}
+
+	return requests
+}
+
+// findPropagationsWaitingForConfigMap maps a ConfigMap to the ConfigMapPropagations that report it as a target they
+// didn't write, because it is claimed by another propagation or is an unmanaged configmap in conflict.
+// Those propagations get to write the target once it is deleted or released, which nothing else notifies them of.
+func (r *ConfigMapPropagationReconciler) findPropagationsWaitingForConfigMap(obj client.Object) []reconcile.Request {
+	ctx := context.Background()
+	logger := log.FromContext(ctx).WithValues("configmap", client.ObjectKeyFromObject(obj))
+
+	var prList kubegoodiesv1.ConfigMapPropagationList
+	if err := r.List(ctx, &prList, client.MatchingFields{claimedTargetIndexKey: client.ObjectKeyFromObject(obj).String()}); err != nil {
+		logger.Error(err, "unable to list ConfigMapPropagations by claimed target")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, pr := range prList.Items {
+		for _, itemStatus := range pr.Status.PropagationStatus {
+			if itemStatus.TargetNamespace == obj.GetNamespace() && itemStatus.TargetName == obj.GetName() && itemStatus.Status != metav1.ConditionTrue {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: pr.Name}})
+				break
+			}
+		}
+	}
+	return requests
+}
+
+// isSourceNamespace returns true when the source watches the given namespace.
+// Namespaces that can't be checked are considered watched.
+func (r *ConfigMapPropagationReconciler) isSourceNamespace(ctx context.Context, src *kubegoodiesv1.PropagationSource, namespace string) bool {
+	if src.NamespaceSelector == nil || src.Namespace == kubegoodiesv1.AllNamespaces || src.Namespace == namespace {
+		return true
+	}
+	for _, ns := range src.Namespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(src.NamespaceSelector)
+	if err != nil {
+		return true
+	}
+
+	var ns corev1.Namespace
+	if err := r.Get(ctx, types.NamespacedName{Name: namespace}, &ns); err != nil {
+		return true
+	}
+	return selector.Matches(labels.Set(ns.Labels))
+}
+
+// findPropagationsForNamespace maps a Namespace to the ConfigMapPropagations that target it, either by listing it
+// or by selecting it with their namespace selector, and to the ones selecting it as a source namespace.
+// As this is called with both the old and the new version of an updated namespace, propagations are notified
+// both when the namespace starts and when it stops matching their selector.
+func (r *ConfigMapPropagationReconciler) findPropagationsForNamespace(obj client.Object) []reconcile.Request {
+	ctx := context.Background()
+	logger := log.FromContext(ctx).WithValues("namespace", obj.GetName())
+
+	var requests []reconcile.Request
+
+	var byName kubegoodiesv1.ConfigMapPropagationList
+	if err := r.List(ctx, &byName, client.MatchingFields{targetNamespaceIndexKey: obj.GetName()}); err != nil {
+		logger.Error(err, "unable to list ConfigMapPropagations by target namespace")
+	}
+	for _, pr := range byName.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: pr.Name}})
+	}
+
+	var byTargetSelector kubegoodiesv1.ConfigMapPropagationList
+	if err := r.List(ctx, &byTargetSelector, client.MatchingFields{targetNamespaceSelectorIndexKey: "true"}); err != nil {
+		logger.Error(err, "unable to list ConfigMapPropagations by target namespace selector")
+	}
+	for i := range byTargetSelector.Items {
+		pr := &byTargetSelector.Items[i]
+		for _, labelSelector := range targetNamespaceSelectors(pr) {
+			selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+			if err == nil && !selector.Matches(labels.Set(obj.GetLabels())) {
+				continue
+			}
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: pr.Name}})
+			break
+		}
+	}
+
+	var bySourceSelector kubegoodiesv1.ConfigMapPropagationList
+	if err := r.List(ctx, &bySourceSelector, client.MatchingFields{sourceNamespaceSelectorIndexKey: "true"}); err != nil {
+		logger.Error(err, "unable to list ConfigMapPropagations by source namespace selector")
+	}
+	for _, pr := range bySourceSelector.Items {
+		selector, err := metav1.LabelSelectorAsSelector(pr.Spec.Source.NamespaceSelector)
+		if err == nil && !selector.Matches(labels.Set(obj.GetLabels())) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: pr.Name}})
+	}
+
+	// the workqueue drops the duplicates
+	return requests
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kubegoodiesv1 "github.com/aliok/kubegoodies/api/v1"
+	"github.com/aliok/kubegoodies/pkg/configmappropagation"
+)
+
+// testIndexers are the field indexes set up by SetupWithManager.
+var testIndexers = map[string]client.IndexerFunc{
+	sourceNameIndexKey:              indexSourceNames,
+	sourceSelectorIndexKey:          indexSourceSelector,
+	sourceNamespaceSelectorIndexKey: indexSourceNamespaceSelector,
+	targetNamespaceIndexKey:         indexTargetNamespaces,
+	targetNamespaceSelectorIndexKey: indexTargetNamespaceSelector,
+	claimedTargetIndexKey:           indexClaimedTargets,
+	targetOwnerIndexKey:             indexTargetOwner,
+}
+
+// indexedClient filters the lists by the field indexes of the controller, which the fake client ignores.
+type indexedClient struct {
+	client.Client
+}
+
+func newTestClient(objs ...client.Object) indexedClient {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = kubegoodiesv1.AddToScheme(scheme)
+	return indexedClient{fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()}
+}
+
+// Patch applies server-side apply patches as merge patches, as the fake client doesn't support them.
+// Field ownership is not tracked, the tests of Execute cover it.
+func (c indexedClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	err = c.Client.Patch(ctx, obj, client.RawPatch(types.MergePatchType, data))
+	if apierrors.IsNotFound(err) {
+		return c.Client.Create(ctx, obj)
+	}
+	return err
+}
+
+func (c indexedClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	if listOpts.FieldSelector == nil || listOpts.FieldSelector.Empty() {
+		return c.Client.List(ctx, list, opts...)
+	}
+
+	requirements := listOpts.FieldSelector.Requirements()
+	listOpts.FieldSelector = nil
+	if err := c.Client.List(ctx, list, &listOpts); err != nil {
+		return err
+	}
+
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+	var matched []runtime.Object
+	for _, item := range items {
+		obj := item.(client.Object)
+		matches := true
+		for _, requirement := range requirements {
+			found := false
+			for _, value := range testIndexers[requirement.Field](obj) {
+				if value == requirement.Value {
+					found = true
+				}
+			}
+			matches = matches && found
+		}
+		if matches {
+			matched = append(matched, item)
+		}
+	}
+	return meta.SetList(list, matched)
+}
+
+func TestFindPropagationsForSourceConfigMap(t *testing.T) {
+	propagation := func(name string, src kubegoodiesv1.PropagationSource) *kubegoodiesv1.ConfigMapPropagation {
+		return &kubegoodiesv1.ConfigMapPropagation{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       kubegoodiesv1.ConfigMapPropagationSpec{Source: src},
+		}
+	}
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "foo"}}
+
+	r := &ConfigMapPropagationReconciler{Client: newTestClient(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "a"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "b", Labels: map[string]string{"team": "x"}}},
+		propagation("by-name", kubegoodiesv1.PropagationSource{Namespace: "a", Names: []string{"cm"}}),
+		propagation("by-selector", kubegoodiesv1.PropagationSource{Namespace: "a", ObjectSelector: selector}),
+		propagation("all-by-name", kubegoodiesv1.PropagationSource{Namespace: kubegoodiesv1.AllNamespaces, Names: []string{"cm"}}),
+		propagation("all-by-selector", kubegoodiesv1.PropagationSource{Namespace: kubegoodiesv1.AllNamespaces, ObjectSelector: selector}),
+		propagation("namespace-selector", kubegoodiesv1.PropagationSource{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "x"}},
+			Names:             []string{"cm"},
+		}),
+		propagation("other-namespace", kubegoodiesv1.PropagationSource{Namespace: "other", Names: []string{"cm"}}),
+	)}
+
+	configMap := func(namespace, name string, labels map[string]string, target bool) *corev1.ConfigMap {
+		cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels}}
+		if target {
+			cm.Annotations = map[string]string{}
+			configmappropagation.SetPropagationAnnotation(cm.Annotations, "other", name)
+		}
+		return cm
+	}
+	selected := map[string]string{"app": "foo"}
+
+	tests := []struct {
+		name string
+		cm   *corev1.ConfigMap
+		want []string
+	}{
+		{
+			name: "by name and selector",
+			cm:   configMap("a", "cm", selected, false),
+			want: []string{"all-by-name", "all-by-selector", "by-name", "by-selector"},
+		},
+		{
+			name: "by selector only",
+			cm:   configMap("a", "other", selected, false),
+			want: []string{"all-by-selector", "by-selector"},
+		},
+		{
+			name: "selected namespace",
+			cm:   configMap("b", "cm", nil, false),
+			want: []string{"all-by-name", "namespace-selector"},
+		},
+		{
+			name: "targets are not sources of all namespaces",
+			cm:   configMap("a", "cm", selected, true),
+			want: []string{"by-name", "by-selector"},
+		},
+		{
+			name: "not a source",
+			cm:   configMap("c", "other", nil, false),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, req := range r.findPropagationsForSourceConfigMap(tt.cm) {
+				got = append(got, req.Name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findPropagationsForSourceConfigMap() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
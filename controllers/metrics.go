@@ -0,0 +1,137 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/aliok/kubegoodies/pkg/configmappropagation"
+)
+
+// values of the result label of propagationsTotal
+const (
+	metricResultCreated   = "created"
+	metricResultUpdated   = "updated"
+	metricResultUnchanged = "unchanged"
+	metricResultDeleted   = "deleted"
+	metricResultFailed    = "failed"
+)
+
+var metricResults = []string{metricResultCreated, metricResultUpdated, metricResultUnchanged, metricResultDeleted, metricResultFailed}
+
+var (
+	propagationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kubegoodies_configmappropagation_propagations_total",
+			Help: "Number of target configmaps processed by a ConfigMapPropagation, by result",
+		},
+		[]string{"propagation", "result"},
+	)
+
+	propagationLatencySeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "kubegoodies_configmappropagation_propagation_latency_seconds",
+			Help:    "Time from the last change of a source configmap until its target configmap is updated with it",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+		},
+		[]string{"propagation"},
+	)
+
+	managedTargets = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kubegoodies_configmappropagation_managed_targets",
+			Help: "Number of target configmaps managed by a ConfigMapPropagation",
+		},
+		[]string{"propagation"},
+	)
+
+	driftCorrectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kubegoodies_configmappropagation_drift_corrections_total",
+			Help: "Number of drifted target configmaps restored by a ConfigMapPropagation",
+		},
+		[]string{"propagation"},
+	)
+
+	conflictsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kubegoodies_configmappropagation_conflicts_total",
+			Help: "Number of target configmaps a ConfigMapPropagation could not write because of a conflict",
+		},
+		[]string{"propagation"},
+	)
+)
+
+func init() {
+	// served by the manager's metrics endpoint
+	metrics.Registry.MustRegister(
+		propagationsTotal,
+		propagationLatencySeconds,
+		managedTargets,
+		driftCorrectionsTotal,
+		conflictsTotal,
+	)
+}
+
+// metricResult returns the result label of propagationsTotal for the result of an execution.
+func metricResult(result configmappropagation.Result) string {
+	switch result {
+	case configmappropagation.ResultCreated:
+		return metricResultCreated
+	case configmappropagation.ResultUpdated, configmappropagation.ResultDriftReverted:
+		return metricResultUpdated
+	case configmappropagation.ResultDeleted:
+		return metricResultDeleted
+	default:
+		return metricResultUnchanged
+	}
+}
+
+// observePropagationLatency records the time passed since the last change of the source configmaps.
+func observePropagationLatency(propagation string, sourceCms ...*corev1.ConfigMap) {
+	var changed time.Time
+	for _, sourceCm := range sourceCms {
+		if sourceCm.CreationTimestamp.After(changed) {
+			changed = sourceCm.CreationTimestamp.Time
+		}
+		for _, entry := range sourceCm.ManagedFields {
+			if entry.Time != nil && entry.Time.After(changed) {
+				changed = entry.Time.Time
+			}
+		}
+	}
+	if changed.IsZero() {
+		return
+	}
+	propagationLatencySeconds.WithLabelValues(propagation).Observe(time.Since(changed).Seconds())
+}
+
+// deletePropagationMetrics drops the series of a deleted ConfigMapPropagation.
+func deletePropagationMetrics(propagation string) {
+	for _, result := range metricResults {
+		propagationsTotal.DeleteLabelValues(propagation, result)
+	}
+	propagationLatencySeconds.DeleteLabelValues(propagation)
+	managedTargets.DeleteLabelValues(propagation)
+	driftCorrectionsTotal.DeleteLabelValues(propagation)
+	conflictsTotal.DeleteLabelValues(propagation)
+}
@@ -52,11 +52,17 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var maxConcurrentReconciles int
+	var maxConcurrentPropagations int
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"The number of ConfigMapPropagations reconciled at the same time.")
+	flag.IntVar(&maxConcurrentPropagations, "max-concurrent-propagations", 10,
+		"The maximum number of target configmaps a ConfigMapPropagation writes at the same time. ConfigMapPropagations can set a lower maxConcurrency.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -79,12 +85,23 @@ func main() {
 	}
 
 	if err = (&controllers.ConfigMapPropagationReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("configmappropagation-controller"),
+
+		MaxConcurrentPropagations: maxConcurrentPropagations,
+		MaxConcurrentReconciles:   maxConcurrentReconciles,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ConfigMapPropagation")
 		os.Exit(1)
 	}
+	// the webhook needs serving certificates, it can be disabled for running the controller locally
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&kubegoodiesv1.ConfigMapPropagation{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ConfigMapPropagation")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
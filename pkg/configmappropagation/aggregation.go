@@ -0,0 +1,163 @@
+package configmappropagation
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kubegoodiesv1 "github.com/aliok/kubegoodies/api/v1"
+)
+
+func isAggregation(req *Request) bool {
+	return len(req.Aggregated) > 0
+}
+
+// targetContent is the data and binary data of a target, merged from its sources.
+type targetContent struct {
+	data       map[string]string
+	binaryData map[string][]byte
+	// provenance is the namespace/name of the source of each key
+	provenance map[string]string
+}
+
+// mergeSources returns the selected and mapped keys of the sources, merged in their order.
+// Keys a source has in common with a previous one are handled according to the key collision strategy of the request.
+func mergeSources(req *Request, sources []*corev1.ConfigMap) (*targetContent, error) {
+	content := &targetContent{provenance: map[string]string{}}
+	target := types.NamespacedName{Namespace: req.TargetNamespace, Name: req.TargetName}
+
+	for _, src := range sources {
+		// keys of a single source can't collide, that's a key mapping error
+		mapKey, err := newTargetKeyMapper(req)
+		if err != nil {
+			return nil, err
+		}
+		source := client.ObjectKeyFromObject(src).String()
+
+		targetKey := func(key string) (string, bool, error) {
+			if !IsKeySelected(req.Keys, key) {
+				return "", false, nil
+			}
+			mapped, err := mapKey(key)
+			if err != nil {
+				return "", false, err
+			}
+			if req.KeyCollisionStrategy == kubegoodiesv1.KeyCollisionStrategyPrefixSourceName {
+				mapped = src.Name + "." + mapped
+				if req.PrefixSourceNamespace {
+					mapped = src.Namespace + "." + mapped
+				}
+				if errs := validation.IsConfigMapKey(mapped); len(errs) > 0 {
+					return "", false, fmt.Errorf("key %q of source %s is prefixed to the invalid key %q: %s", key, source, mapped, strings.Join(errs, ", "))
+				}
+			}
+
+			other, collides := content.provenance[mapped]
+			if !collides {
+				content.provenance[mapped] = source
+				return mapped, true, nil
+			}
+			switch req.KeyCollisionStrategy {
+			case kubegoodiesv1.KeyCollisionStrategyFirstWins:
+				return "", false, nil
+			case kubegoodiesv1.KeyCollisionStrategyLastWins:
+				// data and binary data share their keys
+				delete(content.data, mapped)
+				delete(content.binaryData, mapped)
+				content.provenance[mapped] = source
+				return mapped, true, nil
+			default:
+				return "", false, &KeyCollisionError{Target: target, Key: mapped, Sources: []string{other, source}}
+			}
+		}
+
+		if src.Data != nil && content.data == nil {
+			content.data = make(map[string]string, len(src.Data))
+		}
+		for _, k := range sortedKeys(src.Data) {
+			mapped, ok, err := targetKey(k)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				content.data[mapped] = src.Data[k]
+			}
+		}
+
+		if src.BinaryData != nil && content.binaryData == nil {
+			content.binaryData = make(map[string][]byte, len(src.BinaryData))
+		}
+		for _, k := range sortedBinaryKeys(src.BinaryData) {
+			mapped, ok, err := targetKey(k)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				content.binaryData[mapped] = append([]byte(nil), src.BinaryData[k]...)
+			}
+		}
+	}
+
+	return content, nil
+}
+
+// provenanceAnnotation returns the keys each source provided, as the JSON of PropagationAnnotationSourcesKey.
+func provenanceAnnotation(sources []*corev1.ConfigMap, provenance map[string]string) (string, error) {
+	keys := make(map[string][]string, len(sources))
+	for _, src := range sources {
+		// sources without any propagated key are listed as well
+		keys[client.ObjectKeyFromObject(src).String()] = []string{}
+	}
+	for key, source := range provenance {
+		keys[source] = append(keys[source], key)
+	}
+	for _, sourceKeys := range keys {
+		sort.Strings(sourceKeys)
+	}
+
+	value, err := json.Marshal(keys)
+	if err != nil {
+		return "", fmt.Errorf("error marshalling the sources of the target: %v", err)
+	}
+	return string(value), nil
+}
+
+// mergeImmutable returns the immutability of a target, which is only immutable when all its sources are.
+func mergeImmutable(sources []*corev1.ConfigMap) *bool {
+	var immutable *bool
+	for _, src := range sources {
+		if src.Immutable == nil {
+			return nil
+		}
+		if immutable == nil {
+			immutable = new(bool)
+			*immutable = true
+		}
+		*immutable = *immutable && *src.Immutable
+	}
+	return immutable
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedBinaryKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,130 @@
+package configmappropagation
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubegoodiesv1 "github.com/aliok/kubegoodies/api/v1"
+)
+
+func TestNewTargetAggregation(t *testing.T) {
+	sources := []*corev1.ConfigMap{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ingress"},
+			Data:       map[string]string{"shared": "from-ingress", "ingress.conf": "a"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "logging"},
+			Data:       map[string]string{"shared": "from-logging", "logging.conf": "b"},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		strategy   kubegoodiesv1.KeyCollisionStrategy
+		want       map[string]string
+		provenance string
+		collision  bool
+	}{
+		{
+			name:      "collisions fail by default",
+			collision: true,
+		},
+		{
+			name:       "first wins",
+			strategy:   kubegoodiesv1.KeyCollisionStrategyFirstWins,
+			want:       map[string]string{"shared": "from-ingress", "ingress.conf": "a", "logging.conf": "b"},
+			provenance: `{"default/ingress":["ingress.conf","shared"],"default/logging":["logging.conf"]}`,
+		},
+		{
+			name:       "last wins",
+			strategy:   kubegoodiesv1.KeyCollisionStrategyLastWins,
+			want:       map[string]string{"shared": "from-logging", "ingress.conf": "a", "logging.conf": "b"},
+			provenance: `{"default/ingress":["ingress.conf"],"default/logging":["logging.conf","shared"]}`,
+		},
+		{
+			name:     "prefixed with the source name",
+			strategy: kubegoodiesv1.KeyCollisionStrategyPrefixSourceName,
+			want: map[string]string{
+				"ingress.shared":       "from-ingress",
+				"ingress.ingress.conf": "a",
+				"logging.shared":       "from-logging",
+				"logging.logging.conf": "b",
+			},
+			provenance: `{"default/ingress":["ingress.ingress.conf","ingress.shared"],"default/logging":["logging.logging.conf","logging.shared"]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Request{
+				TargetNamespace:      "team-a",
+				TargetName:           "combined",
+				Aggregated:           sources,
+				KeyCollisionStrategy: tt.strategy,
+			}
+
+			desired, err := newTarget(req, nil, nil)
+			if tt.collision {
+				var collisionErr *KeyCollisionError
+				if !errors.As(err, &collisionErr) {
+					t.Fatalf("expected a key collision error, got %v", err)
+				}
+				if collisionErr.Key != "shared" || !reflect.DeepEqual(collisionErr.Sources, []string{"default/ingress", "default/logging"}) {
+					t.Errorf("unexpected collision %v", collisionErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(desired.Data, tt.want) {
+				t.Errorf("unexpected data %v, want %v", desired.Data, tt.want)
+			}
+			if got := desired.Annotations[PropagationAnnotationSourcesKey]; got != tt.provenance {
+				t.Errorf("unexpected provenance %s, want %s", got, tt.provenance)
+			}
+			if GetPropagationAnnotation(desired.Annotations) != nil || !IsTarget(desired.Annotations) {
+				t.Errorf("expected the aggregated target to be recognized by its sources annotation")
+			}
+		})
+	}
+}
+
+func TestNewTargetAggregationPrefixSourceNamespace(t *testing.T) {
+	req := &Request{
+		TargetNamespace: "team-a",
+		TargetName:      "combined",
+		Aggregated: []*corev1.ConfigMap{
+			{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns-a", Name: "logging"},
+				Data:       map[string]string{"level": "debug"},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns-b", Name: "logging"},
+				Data:       map[string]string{"level": "info"},
+			},
+		},
+		KeyCollisionStrategy:  kubegoodiesv1.KeyCollisionStrategyPrefixSourceName,
+		PrefixSourceNamespace: true,
+	}
+
+	desired, err := newTarget(req, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]string{"ns-a.logging.level": "debug", "ns-b.logging.level": "info"}
+	if !reflect.DeepEqual(desired.Data, want) {
+		t.Errorf("unexpected data %v, want %v", desired.Data, want)
+	}
+
+	// without the namespace, the sources of the same name collide
+	req.PrefixSourceNamespace = false
+	var collisionErr *KeyCollisionError
+	if _, err := newTarget(req, nil, nil); !errors.As(err, &collisionErr) {
+		t.Errorf("expected a key collision error, got %v", err)
+	}
+}
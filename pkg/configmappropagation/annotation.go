@@ -1,6 +1,12 @@
 package configmappropagation
 
-import "k8s.io/apimachinery/pkg/types"
+import (
+	"k8s.io/apimachinery/pkg/types"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubegoodiesv1 "github.com/aliok/kubegoodies/api/v1"
+)
 
 func SetPropagationAnnotation(annotations map[string]string, srcNamespace string, srcName string) {
 	annotations[PropagationAnnotationNamespaceKey] = srcNamespace
@@ -22,3 +28,50 @@ func GetPropagationAnnotation(annotations map[string]string) *types.NamespacedNa
 		Name:      name,
 	}
 }
+
+// IsTarget returns true when the annotations show that the configmap is the target of a propagation.
+func IsTarget(annotations map[string]string) bool {
+	if GetPropagationAnnotation(annotations) != nil {
+		return true
+	}
+	_, aggregated := annotations[PropagationAnnotationSourcesKey]
+	return aggregated
+}
+
+func SetOwnerAnnotation(annotations map[string]string, ownerName string, ownerUID types.UID) {
+	annotations[PropagationAnnotationOwnerNameKey] = ownerName
+	annotations[PropagationAnnotationOwnerUIDKey] = string(ownerUID)
+}
+
+// GetOwnerAnnotation returns the UID of the ConfigMapPropagation that owns the target,
+// or an empty UID when the target carries no owner annotation.
+func GetOwnerAnnotation(annotations map[string]string) types.UID {
+	return types.UID(annotations[PropagationAnnotationOwnerUIDKey])
+}
+
+// NewOwnerReference returns the owner reference that marks a target as controlled by a ConfigMapPropagation.
+// ConfigMapPropagation is cluster-scoped, so it can own configmaps in any namespace.
+func NewOwnerReference(ownerName string, ownerUID types.UID) metav1.OwnerReference {
+	isController := true
+	blockOwnerDeletion := true
+	return metav1.OwnerReference{
+		APIVersion:         kubegoodiesv1.GroupVersion.String(),
+		Kind:               "ConfigMapPropagation",
+		Name:               ownerName,
+		UID:                ownerUID,
+		Controller:         &isController,
+		BlockOwnerDeletion: &blockOwnerDeletion,
+	}
+}
+
+// IsPropagatedFrom returns true when the target annotations show that the target was propagated by the given
+// request. Targets that carry an owner annotation must be owned by the owner of the request, older targets
+// without one must be propagated from the source of the request.
+func IsPropagatedFrom(annotations map[string]string, req *Request) bool {
+	if ownerUID := GetOwnerAnnotation(annotations); ownerUID != "" && req.OwnerUID != "" {
+		return ownerUID == req.OwnerUID
+	}
+
+	src := GetPropagationAnnotation(annotations)
+	return src != nil && src.Namespace == req.SourceNamespace && src.Name == req.SourceName
+}
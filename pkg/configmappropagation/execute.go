@@ -3,85 +3,444 @@ package configmappropagation
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
+
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kubegoodiesv1 "github.com/aliok/kubegoodies/api/v1"
 )
 
-func Execute(ctx context.Context, cl client.Client, req *Request) error {
-	// TODO: what about labels and annotations?
-	// TODO: some way of filtering out stuff?
+func Execute(ctx context.Context, cl client.Client, req *Request) (Outcome, error) {
 	// TODO: set an annotation like "github.com/aliok/bla: DO NOT EDIT. THIS CONFIGMAP IS PROPAGATED FROM namespace/foo"
 
 	logger := log.FromContext(ctx)
 
 	logger.Info("propagating", "request", req)
 
-	if req.SourceNamespace == "" {
-		return fmt.Errorf("sourceNamespace cannot be empty")
+	if !isAggregation(req) && req.SourceNamespace == "" {
+		return Outcome{}, fmt.Errorf("sourceNamespace cannot be empty")
 	}
 
-	if req.SourceName == "" {
-		return fmt.Errorf("sourceName cannot be empty")
+	if !isAggregation(req) && req.SourceName == "" {
+		return Outcome{}, fmt.Errorf("sourceName cannot be empty")
 	}
 
 	if req.TargetNamespace == "" {
-		return fmt.Errorf("targetNamespace cannot be empty")
+		return Outcome{}, fmt.Errorf("targetNamespace cannot be empty")
 	}
 
 	if req.TargetName == "" {
+		if isAggregation(req) {
+			return Outcome{}, fmt.Errorf("targetName cannot be empty when aggregating")
+		}
 		req.TargetName = req.SourceName
 	}
 
-	var sourceCm corev1.ConfigMap
-	err := cl.Get(ctx, types.NamespacedName{Namespace: req.SourceNamespace, Name: req.SourceName}, &sourceCm)
+	// aggregated sources are loaded by the caller, which leaves out the deleted ones
+	var sourceCm *corev1.ConfigMap
+	if !isAggregation(req) {
+		sourceCm = req.Source
+		if sourceCm == nil {
+			sourceCm = &corev1.ConfigMap{}
+			err := cl.Get(ctx, types.NamespacedName{Namespace: req.SourceNamespace, Name: req.SourceName}, sourceCm)
+
+			if apierrors.IsNotFound(err) {
+				return deleteTarget(ctx, cl, req)
+			}
+			if err != nil {
+				return Outcome{}, fmt.Errorf("error getting the source configmap: %v", err)
+			}
+		}
+
+		if sourceCm.DeletionTimestamp != nil {
+			return deleteTarget(ctx, cl, req)
+		}
+	}
 
+	var targetNs *corev1.Namespace
+	if req.Template != nil && req.Template.Enabled {
+		targetNs = &corev1.Namespace{}
+		if err := cl.Get(ctx, types.NamespacedName{Name: req.TargetNamespace}, targetNs); err != nil {
+			return Outcome{}, fmt.Errorf("error getting the target namespace: %v", err)
+		}
+	}
+
+	desired, err := newTarget(req, sourceCm, targetNs)
+	if err != nil {
+		return Outcome{}, err
+	}
+
+	var existing corev1.ConfigMap
+	err = cl.Get(ctx, types.NamespacedName{Namespace: req.TargetNamespace, Name: req.TargetName}, &existing)
 	if err != nil && !apierrors.IsNotFound(err) {
-		return fmt.Errorf("error getting the source configmap: %v", err)
+		return Outcome{}, fmt.Errorf("error getting the target configmap: %v", err)
+	}
+
+	exists := err == nil
+
+	// targets propagated before the source hash was recorded don't tell whether their sources changed
+	previousSourceHash := existing.Annotations[PropagationAnnotationSourceHashKey]
+	sourceChanged := previousSourceHash != "" && previousSourceHash != desired.Annotations[PropagationAnnotationSourceHashKey]
+
+	drifted := false
+	if exists {
+		if err := checkConflict(&existing, req); err != nil {
+			return Outcome{Result: ResultSkipped}, err
+		}
+
+		if existing.Annotations[PropagationAnnotationContentHashKey] == desired.Annotations[PropagationAnnotationContentHashKey] {
+			if isUpToDate(&existing, desired, isMerge(req)) {
+				// nothing changed since the last propagation, skip the write
+				return Outcome{Result: ResultUnchanged}, nil
+			}
+
+			// the source stayed the same, but the target was changed by someone else
+			drifted = true
+			switch req.DriftPolicy {
+			case kubegoodiesv1.DriftPolicyIgnore:
+				return Outcome{Result: ResultUnchanged}, nil
+			case kubegoodiesv1.DriftPolicyReport:
+				logger.Info("target configmap drifted from the source", "request", req)
+				return Outcome{Result: ResultDriftDetected}, nil
+			}
+		}
 	}
 
-	sourceExists := err == nil && sourceCm.DeletionTimestamp == nil
+	if exists {
+		if keys := staleKeys(req, &existing, desired); len(keys) > 0 {
+			if err := removeKeys(ctx, cl, &existing, keys); err != nil {
+				return Outcome{}, err
+			}
+		}
+	}
+
+	// the applied configuration only has the fields we manage, fields of other managers are kept.
+	// Fields we applied before and don't apply anymore are removed by the API server.
+	targetCm := desired.DeepCopy()
+	targetCm.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}
 
-	if !sourceExists {
-		targetCm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: req.TargetNamespace, Name: req.TargetName}}
-		if err := cl.Delete(ctx, targetCm); client.IgnoreNotFound(err) != nil {
-			return fmt.Errorf("error deleting the target configmap: %v", err)
+	// the fields of other managers are only taken over when the target is taken over as the conflict policy
+	// allows, or when its drift is reverted. A source change conflicting with the changes of another manager
+	// fails instead, so that those changes aren't lost silently.
+	opts := []client.PatchOption{client.FieldOwner(FieldManager)}
+	if drifted || (exists && !IsPropagatedFrom(existing.Annotations, req)) {
+		opts = append(opts, client.ForceOwnership)
+	}
+	if err := cl.Patch(ctx, targetCm, client.Apply, opts...); err != nil {
+		if apierrors.IsConflict(err) {
+			return Outcome{Result: ResultSkipped}, &FieldManagerConflictError{
+				Target:  types.NamespacedName{Namespace: req.TargetNamespace, Name: req.TargetName},
+				Message: err.Error(),
+			}
 		}
-		return nil
+		return Outcome{}, fmt.Errorf("error applying the target configmap: %v", err)
+	}
+
+	switch {
+	case !exists:
+		logger.Info("propagated", "request", req, "operation", ResultCreated)
+		return Outcome{Result: ResultCreated}, nil
+	case targetCm.ResourceVersion == existing.ResourceVersion:
+		return Outcome{Result: ResultUnchanged}, nil
+	case drifted:
+		logger.Info("reverted drift of the target configmap", "request", req)
+		return Outcome{Result: ResultDriftReverted}, nil
+	default:
+		logger.Info("propagated", "request", req, "operation", ResultUpdated)
+		return Outcome{Result: ResultUpdated, SourceChanged: sourceChanged}, nil
+	}
+}
+
+// newTarget returns the target configmap the request propagates the source to, or the aggregated sources
+// of the request when aggregating. When the target namespace is given, the data values are rendered as
+// templates for it.
+// The sources are shared by the requests executed in parallel, so nothing of them is modified or handed out.
+func newTarget(req *Request, sourceCm *corev1.ConfigMap, targetNs *corev1.Namespace) (*corev1.ConfigMap, error) {
+	sources := []*corev1.ConfigMap{sourceCm}
+	if isAggregation(req) {
+		sources = req.Aggregated
+	}
+
+	// the labels and annotations of later sources win
+	annotations := map[string]string{}
+	labels := map[string]string{}
+	for _, src := range sources {
+		for k, v := range copiedAnnotations(req.MetadataPolicy, src.Annotations) {
+			annotations[k] = v
+		}
+		for k, v := range copiedLabels(req.MetadataPolicy, src.Labels) {
+			labels[k] = v
+		}
+	}
+
+	// the overrides come after the content of the source, in their order
+	for _, override := range req.Overrides {
+		for k, v := range override.Annotations {
+			annotations[k] = v
+		}
+		for k, v := range override.Labels {
+			labels[k] = v
+		}
+	}
+
+	content, err := mergeSources(req, sources)
+	if err != nil {
+		return nil, err
+	}
+
+	// set our custom label and annotations, which can't be overridden
+	for k, v := range fixedLabels(req.MetadataPolicy) {
+		labels[k] = v
+	}
+	if isAggregation(req) {
+		provenance, err := provenanceAnnotation(sources, content.provenance)
+		if err != nil {
+			return nil, err
+		}
+		annotations[PropagationAnnotationSourcesKey] = provenance
+	} else {
+		SetPropagationAnnotation(annotations, req.SourceNamespace, req.SourceName)
 	}
 
-	// clone informer's copy
-	var annotations = make(map[string]string, len(sourceCm.Annotations))
-	for k, v := range sourceCm.Annotations {
-		annotations[k] = v
+	var ownerRefs []metav1.OwnerReference
+	if req.OwnerUID != "" {
+		SetOwnerAnnotation(annotations, req.OwnerName, req.OwnerUID)
+		ownerRefs = []metav1.OwnerReference{NewOwnerReference(req.OwnerName, req.OwnerUID)}
 	}
 
-	// set our custom annotation
-	SetPropagationAnnotation(annotations, req.SourceNamespace, req.SourceName)
+	data, binaryData := content.data, content.binaryData
 
-	targetCm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: req.TargetNamespace, Name: req.TargetName}}
+	if targetNs != nil {
+		target := types.NamespacedName{Namespace: req.TargetNamespace, Name: req.TargetName}
+		if err := renderValues(data, newTemplateData(req, targetNs), target); err != nil {
+			return nil, err
+		}
+	}
 
-	op, err := controllerutil.CreateOrPatch(ctx, cl, targetCm, func() error {
-		targetCm.Annotations = annotations // copy source annotations and add our annotation
-		targetCm.Labels = sourceCm.Labels  // copy source labels
-		targetCm.OwnerReferences = nil     // cannot set cross namespace ownerRef to source configMap
+	for _, override := range req.Overrides {
+		for k, v := range override.Data {
+			if data == nil {
+				data = map[string]string{}
+			}
+			data[k] = v
+			// data and binary data share their keys
+			delete(binaryData, k)
+		}
+	}
 
-		targetCm.Immutable = sourceCm.Immutable
-		targetCm.Data = sourceCm.Data
-		targetCm.BinaryData = sourceCm.BinaryData
+	desired := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       req.TargetNamespace,
+			Name:            req.TargetName,
+			Annotations:     annotations,
+			Labels:          labels,
+			OwnerReferences: ownerRefs,
+		},
+		Immutable:  mergeImmutable(sources),
+		Data:       data,
+		BinaryData: binaryData,
+	}
+	if isMerge(req) {
+		setOwnedKeys(desired)
+	}
+	annotations[PropagationAnnotationSourceHashKey] = SourceHash(sources...)
+	annotations[PropagationAnnotationContentHashKey] = ContentHash(desired)
 
+	return desired, nil
+}
+
+// newTargetKeyMapper returns a function mapping the keys of the source to the keys of the target of the request.
+// The function fails when a key is mapped to an invalid key or to the key another key was mapped to before,
+// as the data and binary data of a configmap share their keys.
+func newTargetKeyMapper(req *Request) (func(string) (string, error), error) {
+	mapper, err := newKeyMapper(req.KeyMappings)
+	if err != nil {
+		return nil, err
+	}
+
+	mappedFrom := map[string]string{}
+	return func(key string) (string, error) {
+		mapped := mapper.mapKey(key)
+		if other, ok := mappedFrom[mapped]; ok {
+			keys := []string{other, key}
+			sort.Strings(keys)
+			return "", &KeyMappingCollisionError{
+				Target:    types.NamespacedName{Namespace: req.TargetNamespace, Name: req.TargetName},
+				Keys:      keys,
+				MappedKey: mapped,
+			}
+		}
+		if mapped != key {
+			if errs := validation.IsConfigMapKey(mapped); len(errs) > 0 {
+				return "", fmt.Errorf("key %q is mapped to the invalid key %q: %s", key, mapped, strings.Join(errs, ", "))
+			}
+		}
+		mappedFrom[mapped] = key
+		return mapped, nil
+	}, nil
+}
+
+// isUpToDate returns true when the existing target has the content of the desired target and is owned like it.
+// Labels and annotations added to the target by others are not considered, neither are keys when merging.
+func isUpToDate(existing *corev1.ConfigMap, desired *corev1.ConfigMap, merge bool) bool {
+	if merge {
+		if !containsStrings(existing.Data, desired.Data) || !containsBytes(existing.BinaryData, desired.BinaryData) {
+			return false
+		}
+	} else if !equality.Semantic.DeepEqual(existing.Data, desired.Data) ||
+		!equality.Semantic.DeepEqual(existing.BinaryData, desired.BinaryData) {
+		return false
+	}
+
+	if !equality.Semantic.DeepEqual(existing.Immutable, desired.Immutable) {
+		return false
+	}
+
+	if !containsStrings(existing.Labels, desired.Labels) || !containsStrings(existing.Annotations, desired.Annotations) {
+		return false
+	}
+
+	for _, desiredRef := range desired.OwnerReferences {
+		found := false
+		for _, ref := range existing.OwnerReferences {
+			if ref.UID == desiredRef.UID && ref.Controller != nil && *ref.Controller {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func copyStrings(m map[string]string) map[string]string {
+	if m == nil {
 		return nil
-	})
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// containsStrings returns true when all entries of sub are in m.
+func containsStrings(m map[string]string, sub map[string]string) bool {
+	for k, v := range sub {
+		if actual, ok := m[k]; !ok || actual != v {
+			return false
+		}
+	}
+	return true
+}
+
+// checkConflict returns a ConflictError when the existing target is not propagated by the request and
+// the conflict policy of the request doesn't allow taking it over.
+func checkConflict(targetCm *corev1.ConfigMap, req *Request) error {
+	if IsPropagatedFrom(targetCm.Annotations, req) {
+		return nil
+	}
+
+	owner := "not managed by any ConfigMapPropagation"
+	managed := false
+	if ownerName := targetCm.Annotations[PropagationAnnotationOwnerNameKey]; ownerName != "" {
+		owner = fmt.Sprintf("managed by ConfigMapPropagation %s", ownerName)
+		managed = true
+	} else if src := GetPropagationAnnotation(targetCm.Annotations); src != nil {
+		owner = fmt.Sprintf("propagated from %s", src)
+		managed = true
+	}
+
+	if managed && req.Preempt {
+		return nil
+	}
 
+	switch req.ConflictPolicy {
+	case kubegoodiesv1.ConflictPolicyOverwrite:
+		return nil
+	case kubegoodiesv1.ConflictPolicyAdopt:
+		if !managed {
+			return nil
+		}
+	}
+
+	return &ConflictError{
+		Target: types.NamespacedName{Namespace: req.TargetNamespace, Name: req.TargetName},
+		Owner:  owner,
+	}
+}
+
+// deleteTarget deletes the target of the request for Execute, as the source is missing.
+func deleteTarget(ctx context.Context, cl client.Client, req *Request) (Outcome, error) {
+	result, err := DeleteTarget(ctx, cl, req)
+	return Outcome{Result: result, SourceMissing: true}, err
+}
+
+// DeleteTarget deletes the target configmap of the request.
+// The target is only deleted when its annotations show that it was propagated by the request, so that
+// configmaps that are not created by the propagation are left alone.
+func DeleteTarget(ctx context.Context, cl client.Client, req *Request) (Result, error) {
+	logger := log.FromContext(ctx)
+
+	var targetCm corev1.ConfigMap
+	err := cl.Get(ctx, types.NamespacedName{Namespace: req.TargetNamespace, Name: req.TargetName}, &targetCm)
+	if apierrors.IsNotFound(err) {
+		return ResultUnchanged, nil
+	}
 	if err != nil {
-		return fmt.Errorf("error applying the target configmap: %v", err)
+		return "", fmt.Errorf("error getting the target configmap: %v", err)
+	}
+
+	if !IsPropagatedFrom(targetCm.Annotations, req) {
+		logger.Info("not deleting target configmap that is not propagated by the request", "request", req)
+		return ResultUnchanged, nil
+	}
+
+	if err := cl.Delete(ctx, &targetCm, client.Preconditions{UID: &targetCm.UID}); client.IgnoreNotFound(err) != nil {
+		return "", fmt.Errorf("error deleting the target configmap: %v", err)
+	}
+
+	logger.Info("deleted target configmap", "request", req)
+
+	return ResultDeleted, nil
+}
+
+// ReleaseTarget removes the ownership of the given ConfigMapPropagation from the target configmap, so that
+// the target is kept when the ConfigMapPropagation is deleted.
+func ReleaseTarget(ctx context.Context, cl client.Client, targetCm *corev1.ConfigMap, ownerUID types.UID) error {
+	logger := log.FromContext(ctx)
+
+	patch := client.MergeFrom(targetCm.DeepCopy())
+
+	var ownerRefs []metav1.OwnerReference
+	for _, ref := range targetCm.OwnerReferences {
+		if ref.UID != ownerUID {
+			ownerRefs = append(ownerRefs, ref)
+		}
+	}
+	targetCm.OwnerReferences = ownerRefs
+
+	if GetOwnerAnnotation(targetCm.Annotations) == ownerUID {
+		delete(targetCm.Annotations, PropagationAnnotationOwnerNameKey)
+		delete(targetCm.Annotations, PropagationAnnotationOwnerUIDKey)
+	}
+
+	if err := cl.Patch(ctx, targetCm, patch); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("error releasing the target configmap: %v", err)
 	}
 
-	logger.Info("propagated", "request", req, "operation", op)
+	logger.Info("released target configmap", "configmap", client.ObjectKeyFromObject(targetCm))
 
 	return nil
 }
@@ -0,0 +1,480 @@
+package configmappropagation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kubegoodiesv1 "github.com/aliok/kubegoodies/api/v1"
+)
+
+// applyClient approximates server-side apply with merge patches, as the fake client doesn't support it.
+// It tracks the data values applied by each field manager, and like the API server, it refuses an apply
+// without ForceOwnership that changes a value set by someone else since the last apply.
+type applyClient struct {
+	client.Client
+	// applied has the data values last applied to each configmap
+	applied map[types.NamespacedName]map[string]string
+}
+
+func newApplyClient(objs ...client.Object) applyClient {
+	return applyClient{
+		Client:  fake.NewClientBuilder().WithObjects(objs...).Build(),
+		applied: map[types.NamespacedName]map[string]string{},
+	}
+}
+
+func (c applyClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+
+	patchOpts := &client.PatchOptions{}
+	patchOpts.ApplyOptions(opts)
+	if patchOpts.FieldManager == "" {
+		return fmt.Errorf("apply requires a field manager")
+	}
+
+	key := client.ObjectKeyFromObject(obj)
+	cm := obj.(*corev1.ConfigMap)
+
+	var existing corev1.ConfigMap
+	if err := c.Client.Get(ctx, key, &existing); err == nil && (patchOpts.Force == nil || !*patchOpts.Force) {
+		for k, v := range cm.Data {
+			current, ok := existing.Data[k]
+			if ok && current != v && current != c.applied[key][k] {
+				return apierrors.NewConflict(corev1.Resource("configmaps"), key.Name, fmt.Errorf("conflict with another manager: .data.%s", k))
+			}
+		}
+	}
+
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	c.applied[key] = copyStrings(cm.Data)
+	err = c.Client.Patch(ctx, obj, client.RawPatch(types.MergePatchType, data))
+	if apierrors.IsNotFound(err) {
+		return c.Client.Create(ctx, obj)
+	}
+	return err
+}
+
+func TestExecute(t *testing.T) {
+	ctx := context.Background()
+
+	sourceCm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "src", Name: "cm", Labels: map[string]string{"app": "foo"}},
+		Data:       map[string]string{"key": "value"},
+	}
+	cl := newApplyClient(sourceCm)
+
+	req := &Request{
+		SourceNamespace: "src",
+		SourceName:      "cm",
+		TargetNamespace: "target",
+		TargetName:      "cm",
+		OwnerName:       "pr",
+		OwnerUID:        "pr-uid",
+		ConflictPolicy:  kubegoodiesv1.ConflictPolicyFail,
+		DriftPolicy:     kubegoodiesv1.DriftPolicyRevert,
+	}
+	targetKey := types.NamespacedName{Namespace: "target", Name: "cm"}
+
+	expectResult := func(want Result) Outcome {
+		t.Helper()
+		got, err := Execute(ctx, cl, req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got.Result != want {
+			t.Fatalf("expected result %s, got %s", want, got.Result)
+		}
+		return got
+	}
+
+	if expectResult(ResultCreated).SourceChanged {
+		t.Fatalf("expected a new target not to be reported as a source change")
+	}
+
+	var targetCm corev1.ConfigMap
+	if err := cl.Get(ctx, targetKey, &targetCm); err != nil {
+		t.Fatalf("unable to get target: %v", err)
+	}
+	if targetCm.Annotations[PropagationAnnotationContentHashKey] == "" {
+		t.Fatalf("expected the content hash annotation on the target")
+	}
+
+	// no write when nothing changed, even with labels added to the target by others
+	targetCm.Labels["added-by"] = "someone-else"
+	if err := cl.Update(ctx, &targetCm); err != nil {
+		t.Fatalf("unable to update target: %v", err)
+	}
+	resourceVersion := targetCm.ResourceVersion
+	expectResult(ResultUnchanged)
+	if err := cl.Get(ctx, targetKey, &targetCm); err != nil {
+		t.Fatalf("unable to get target: %v", err)
+	}
+	if targetCm.ResourceVersion != resourceVersion {
+		t.Fatalf("expected no write for an up to date target")
+	}
+
+	// drifted data is restored
+	targetCm.Data["key"] = "changed"
+	if err := cl.Update(ctx, &targetCm); err != nil {
+		t.Fatalf("unable to update target: %v", err)
+	}
+	if expectResult(ResultDriftReverted).SourceChanged {
+		t.Fatalf("expected reverted drift not to be reported as a source change")
+	}
+	if err := cl.Get(ctx, targetKey, &targetCm); err != nil {
+		t.Fatalf("unable to get target: %v", err)
+	}
+	if targetCm.Data["key"] != "value" {
+		t.Fatalf("expected the drifted data to be restored, got %q", targetCm.Data["key"])
+	}
+
+	// drift is only reported with the Report policy
+	req.DriftPolicy = kubegoodiesv1.DriftPolicyReport
+	targetCm.Data["key"] = "changed"
+	if err := cl.Update(ctx, &targetCm); err != nil {
+		t.Fatalf("unable to update target: %v", err)
+	}
+	expectResult(ResultDriftDetected)
+
+	// drift is left alone with the Ignore policy
+	req.DriftPolicy = kubegoodiesv1.DriftPolicyIgnore
+	expectResult(ResultUnchanged)
+
+	// a source change doesn't take over the value someone else changed
+	var src corev1.ConfigMap
+	if err := cl.Get(ctx, types.NamespacedName{Namespace: "src", Name: "cm"}, &src); err != nil {
+		t.Fatalf("unable to get source: %v", err)
+	}
+	src.Data["key"] = "new-value"
+	if err := cl.Update(ctx, &src); err != nil {
+		t.Fatalf("unable to update source: %v", err)
+	}
+	outcome, err := Execute(ctx, cl, req)
+	var fieldConflictErr *FieldManagerConflictError
+	if !errors.As(err, &fieldConflictErr) || outcome.Result != ResultSkipped {
+		t.Fatalf("expected a FieldManagerConflictError, got %s, %v", outcome.Result, err)
+	}
+	if err := cl.Get(ctx, targetKey, &targetCm); err != nil {
+		t.Fatalf("unable to get target: %v", err)
+	}
+	if targetCm.Data["key"] != "changed" {
+		t.Fatalf("expected the value of the other manager to be kept, got %q", targetCm.Data["key"])
+	}
+
+	// once the other manager gives up its change, the source change is propagated
+	targetCm.Data["key"] = "value"
+	if err := cl.Update(ctx, &targetCm); err != nil {
+		t.Fatalf("unable to update target: %v", err)
+	}
+	if !expectResult(ResultUpdated).SourceChanged {
+		t.Fatalf("expected the update to be reported as a source change")
+	}
+	if err := cl.Get(ctx, targetKey, &targetCm); err != nil {
+		t.Fatalf("unable to get target: %v", err)
+	}
+	if targetCm.Data["key"] != "new-value" {
+		t.Fatalf("expected the source change to be propagated, got %q", targetCm.Data["key"])
+	}
+
+	// a change of the propagation is not a source change
+	req.Overrides = []kubegoodiesv1.TargetOverride{{Name: "override", Labels: map[string]string{"env": "test"}}}
+	if expectResult(ResultUpdated).SourceChanged {
+		t.Fatalf("expected an override change not to be reported as a source change")
+	}
+
+	// an adopted target is taken over, including the values someone else set
+	adopted := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "target", Name: "adopted"},
+		Data:       map[string]string{"key": "unmanaged"},
+	}
+	if err := cl.Create(ctx, adopted); err != nil {
+		t.Fatalf("unable to create target: %v", err)
+	}
+	req.TargetName = "adopted"
+	req.ConflictPolicy = kubegoodiesv1.ConflictPolicyAdopt
+	expectResult(ResultUpdated)
+	if err := cl.Get(ctx, client.ObjectKeyFromObject(adopted), adopted); err != nil {
+		t.Fatalf("unable to get target: %v", err)
+	}
+	if adopted.Data["key"] != "new-value" {
+		t.Fatalf("expected the adopted target to be taken over, got %q", adopted.Data["key"])
+	}
+}
+
+func TestExecuteSyncMode(t *testing.T) {
+	ctx := context.Background()
+
+	sourceCm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "src", Name: "cm"},
+		Data:       map[string]string{"a": "1", "b": "2"},
+	}
+	cl := newApplyClient(sourceCm)
+
+	req := &Request{
+		SourceNamespace: "src",
+		SourceName:      "cm",
+		TargetNamespace: "target",
+		TargetName:      "cm",
+		OwnerName:       "pr",
+		OwnerUID:        "pr-uid",
+		ConflictPolicy:  kubegoodiesv1.ConflictPolicyFail,
+		DriftPolicy:     kubegoodiesv1.DriftPolicyRevert,
+		SyncMode:        kubegoodiesv1.SyncModeMerge,
+	}
+	targetKey := types.NamespacedName{Namespace: "target", Name: "cm"}
+
+	execute := func(want Result) *corev1.ConfigMap {
+		t.Helper()
+		got, err := Execute(ctx, cl, req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got.Result != want {
+			t.Fatalf("expected result %s, got %s", want, got.Result)
+		}
+		var targetCm corev1.ConfigMap
+		if err := cl.Get(ctx, targetKey, &targetCm); err != nil {
+			t.Fatalf("unable to get target: %v", err)
+		}
+		return &targetCm
+	}
+
+	targetCm := execute(ResultCreated)
+	if got := targetCm.Annotations[PropagationAnnotationOwnedKeysKey]; got != "a,b" {
+		t.Fatalf("unexpected owned keys %q", got)
+	}
+
+	// local keys are left alone when merging
+	targetCm.Data["local"] = "mine"
+	if err := cl.Update(ctx, targetCm); err != nil {
+		t.Fatalf("unable to update target: %v", err)
+	}
+	targetCm = execute(ResultUnchanged)
+
+	// keys removed from the source are removed from the target
+	var src corev1.ConfigMap
+	if err := cl.Get(ctx, types.NamespacedName{Namespace: "src", Name: "cm"}, &src); err != nil {
+		t.Fatalf("unable to get source: %v", err)
+	}
+	delete(src.Data, "b")
+	if err := cl.Update(ctx, &src); err != nil {
+		t.Fatalf("unable to update source: %v", err)
+	}
+	targetCm = execute(ResultUpdated)
+	if _, ok := targetCm.Data["b"]; ok {
+		t.Fatalf("expected the key removed from the source to be removed from the target")
+	}
+	if targetCm.Data["local"] != "mine" {
+		t.Fatalf("expected the local key to be kept, got %v", targetCm.Data)
+	}
+
+	// local keys are removed when replacing
+	req.SyncMode = kubegoodiesv1.SyncModeReplace
+	targetCm = execute(ResultUpdated)
+	if _, ok := targetCm.Data["local"]; ok {
+		t.Fatalf("expected the local key to be removed, got %v", targetCm.Data)
+	}
+}
+
+func TestNewTargetOverrides(t *testing.T) {
+	req := &Request{
+		TargetNamespace: "team-a",
+		TargetName:      "cm",
+		OwnerName:       "pr",
+		OwnerUID:        "uid",
+		Overrides: []kubegoodiesv1.TargetOverride{
+			{
+				Name:   "first",
+				Data:   map[string]string{"level": "warn", "raw": "text"},
+				Labels: map[string]string{"tier": "prod"},
+			},
+			{
+				Name:        "second",
+				Data:        map[string]string{"level": "debug"},
+				Annotations: map[string]string{PropagationAnnotationOwnerNameKey: "someone-else"},
+			},
+		},
+	}
+	sourceCm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"tier": "dev", "app": "x"}},
+		Data:       map[string]string{"level": "info", "url": "http://svc"},
+		BinaryData: map[string][]byte{"raw": []byte("bin")},
+	}
+
+	desired, err := newTarget(req, sourceCm, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := desired.Data["level"]; got != "debug" {
+		t.Errorf("expected the last override to win, got %q", got)
+	}
+	if got := desired.Data["url"]; got != "http://svc" {
+		t.Errorf("unexpected url %q", got)
+	}
+	if got := desired.Data["raw"]; got != "text" {
+		t.Errorf("unexpected raw %q", got)
+	}
+	if _, ok := desired.BinaryData["raw"]; ok {
+		t.Errorf("expected the overridden key to be removed from binaryData")
+	}
+	if got := desired.Labels["tier"]; got != "prod" {
+		t.Errorf("unexpected tier label %q", got)
+	}
+	if got := desired.Labels["app"]; got != "x" {
+		t.Errorf("unexpected app label %q", got)
+	}
+	if got := desired.Annotations[PropagationAnnotationOwnerNameKey]; got != "pr" {
+		t.Errorf("expected the propagation annotations not to be overridable, got %q", got)
+	}
+	if sourceCm.Data["level"] != "info" || sourceCm.Labels["tier"] != "dev" {
+		t.Errorf("expected the source to be left alone")
+	}
+}
+
+func TestCheckConflict(t *testing.T) {
+	unmanaged := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+
+	managed := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+	SetPropagationAnnotation(managed.Annotations, "src", "cm")
+	SetOwnerAnnotation(managed.Annotations, "other", "other-uid")
+
+	owned := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+	SetPropagationAnnotation(owned.Annotations, "src", "cm")
+	SetOwnerAnnotation(owned.Annotations, "pr", "pr-uid")
+
+	tests := []struct {
+		policy       kubegoodiesv1.ConflictPolicy
+		preempt      bool
+		target       *corev1.ConfigMap
+		wantConflict bool
+	}{
+		{policy: kubegoodiesv1.ConflictPolicyFail, target: unmanaged, wantConflict: true},
+		{policy: kubegoodiesv1.ConflictPolicyFail, target: managed, wantConflict: true},
+		{policy: kubegoodiesv1.ConflictPolicyFail, target: owned},
+		{policy: kubegoodiesv1.ConflictPolicySkip, target: unmanaged, wantConflict: true},
+		{policy: kubegoodiesv1.ConflictPolicySkip, target: managed, wantConflict: true},
+		{policy: kubegoodiesv1.ConflictPolicySkip, target: owned},
+		{policy: kubegoodiesv1.ConflictPolicyAdopt, target: unmanaged},
+		{policy: kubegoodiesv1.ConflictPolicyAdopt, target: managed, wantConflict: true},
+		{policy: kubegoodiesv1.ConflictPolicyAdopt, target: owned},
+		{policy: kubegoodiesv1.ConflictPolicyOverwrite, target: unmanaged},
+		{policy: kubegoodiesv1.ConflictPolicyOverwrite, target: managed},
+		{policy: kubegoodiesv1.ConflictPolicyOverwrite, target: owned},
+		// outranked propagations' targets are taken over, unmanaged ones are still subject to the policy
+		{policy: kubegoodiesv1.ConflictPolicyFail, preempt: true, target: managed},
+		{policy: kubegoodiesv1.ConflictPolicyFail, preempt: true, target: unmanaged, wantConflict: true},
+	}
+
+	name := func(cm *corev1.ConfigMap) string {
+		switch cm {
+		case unmanaged:
+			return "unmanaged"
+		case managed:
+			return "managed"
+		default:
+			return "owned"
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%s/preempt=%v", tt.policy, name(tt.target), tt.preempt), func(t *testing.T) {
+			req := &Request{
+				SourceNamespace: "src",
+				SourceName:      "cm",
+				TargetNamespace: "target",
+				TargetName:      "cm",
+				OwnerName:       "pr",
+				OwnerUID:        "pr-uid",
+				ConflictPolicy:  tt.policy,
+				Preempt:         tt.preempt,
+			}
+
+			err := checkConflict(tt.target, req)
+			var conflictErr *ConflictError
+			if gotConflict := errors.As(err, &conflictErr); gotConflict != tt.wantConflict {
+				t.Fatalf("checkConflict() error = %v, want conflict %v", err, tt.wantConflict)
+			}
+			if err != nil && conflictErr == nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestReleaseTarget(t *testing.T) {
+	ctx := context.Background()
+
+	ownerRef := NewOwnerReference("pr", "pr-uid")
+	otherRef := metav1.OwnerReference{APIVersion: "v1", Kind: "ConfigMap", Name: "other", UID: "other-uid"}
+
+	tests := []struct {
+		name            string
+		ownerUID        types.UID
+		wantRefs        []metav1.OwnerReference
+		wantAnnotations bool
+	}{
+		{
+			name:     "owned",
+			ownerUID: "pr-uid",
+			wantRefs: []metav1.OwnerReference{otherRef},
+		},
+		{
+			name:            "owned by another propagation",
+			ownerUID:        "another-uid",
+			wantRefs:        []metav1.OwnerReference{ownerRef, otherRef},
+			wantAnnotations: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targetCm := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:       "target",
+					Name:            "cm",
+					Annotations:     map[string]string{},
+					OwnerReferences: []metav1.OwnerReference{ownerRef, otherRef},
+				},
+			}
+			SetPropagationAnnotation(targetCm.Annotations, "src", "cm")
+			SetOwnerAnnotation(targetCm.Annotations, "pr", "pr-uid")
+			cl := fake.NewClientBuilder().WithObjects(targetCm).Build()
+
+			if err := cl.Get(ctx, client.ObjectKeyFromObject(targetCm), targetCm); err != nil {
+				t.Fatalf("unable to get target: %v", err)
+			}
+			if err := ReleaseTarget(ctx, cl, targetCm, tt.ownerUID); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var got corev1.ConfigMap
+			if err := cl.Get(ctx, client.ObjectKeyFromObject(targetCm), &got); err != nil {
+				t.Fatalf("unable to get target: %v", err)
+			}
+			if !reflect.DeepEqual(got.OwnerReferences, tt.wantRefs) {
+				t.Errorf("owner references = %v, want %v", got.OwnerReferences, tt.wantRefs)
+			}
+			if hasAnnotations := GetOwnerAnnotation(got.Annotations) != ""; hasAnnotations != tt.wantAnnotations {
+				t.Errorf("owner annotations = %v, want %v", got.Annotations, tt.wantAnnotations)
+			}
+			if GetPropagationAnnotation(got.Annotations) == nil {
+				t.Errorf("expected the source annotations to be kept")
+			}
+		})
+	}
+}
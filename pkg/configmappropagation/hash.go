@@ -0,0 +1,49 @@
+package configmappropagation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ContentHash returns a hash of the data, binary data, labels and annotations of the configmap.
+// The content hash annotation itself is not part of the hash.
+func ContentHash(cm *corev1.ConfigMap) string {
+	annotations := cm.Annotations
+	if _, ok := annotations[PropagationAnnotationContentHashKey]; ok {
+		annotations = copyStrings(annotations)
+		delete(annotations, PropagationAnnotationContentHashKey)
+	}
+
+	content := struct {
+		Data        map[string]string `json:"data,omitempty"`
+		BinaryData  map[string][]byte `json:"binaryData,omitempty"`
+		Labels      map[string]string `json:"labels,omitempty"`
+		Annotations map[string]string `json:"annotations,omitempty"`
+		Immutable   *bool             `json:"immutable,omitempty"`
+	}{
+		Data:        cm.Data,
+		BinaryData:  cm.BinaryData,
+		Labels:      cm.Labels,
+		Annotations: annotations,
+		Immutable:   cm.Immutable,
+	}
+
+	// maps are marshalled with sorted keys, and maps of strings and bytes can't fail to marshal
+	b, _ := json.Marshal(content)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// SourceHash returns a hash of the names and contents of the source configmaps.
+func SourceHash(sourceCms ...*corev1.ConfigMap) string {
+	h := sha256.New()
+	for _, sourceCm := range sourceCms {
+		// the hashes are of fixed length, so the names can't run into the next source
+		fmt.Fprintf(h, "%s/%s\x00%s", sourceCm.Namespace, sourceCm.Name, ContentHash(sourceCm))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,138 @@
+package configmappropagation
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	kubegoodiesv1 "github.com/aliok/kubegoodies/api/v1"
+)
+
+// ValidateKeyFilter returns an error when a pattern of the filter is malformed.
+func ValidateKeyFilter(filter *kubegoodiesv1.KeyFilter) error {
+	if filter == nil {
+		return nil
+	}
+	for _, patterns := range [][]string{filter.Include, filter.Exclude} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid key pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// IsKeySelected returns true when the key is selected by the filter. A nil filter selects all keys.
+// Configmap keys can't contain "/", so the patterns are matched like paths.
+func IsKeySelected(filter *kubegoodiesv1.KeyFilter, key string) bool {
+	if filter == nil {
+		return true
+	}
+	if len(filter.Include) > 0 && !matchesAny(filter.Include, key) {
+		return false
+	}
+	return !matchesAny(filter.Exclude, key)
+}
+
+// FilteredKeys returns the sorted keys of the data and binary data of the configmap that are not selected by the filter.
+func FilteredKeys(filter *kubegoodiesv1.KeyFilter, cm *corev1.ConfigMap) []string {
+	if filter == nil {
+		return nil
+	}
+
+	var keys []string
+	for k := range cm.Data {
+		if !IsKeySelected(filter, k) {
+			keys = append(keys, k)
+		}
+	}
+	for k := range cm.BinaryData {
+		if !IsKeySelected(filter, k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func matchesAny(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		// malformed patterns are rejected by the validation of the spec
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// keyMapper renames keys by key mappings.
+type keyMapper struct {
+	mappings []kubegoodiesv1.KeyMapping
+	// regexps has the compiled From of each regex mapping, nil for the others
+	regexps []*regexp.Regexp
+}
+
+func newKeyMapper(mappings []kubegoodiesv1.KeyMapping) (*keyMapper, error) {
+	m := &keyMapper{mappings: mappings, regexps: make([]*regexp.Regexp, len(mappings))}
+	for i, mapping := range mappings {
+		if !mapping.Regex {
+			continue
+		}
+		// the whole key must match
+		re, err := regexp.Compile("^(?:" + mapping.From + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q in key mapping: %v", mapping.From, err)
+		}
+		m.regexps[i] = re
+	}
+	return m, nil
+}
+
+// mapKey returns the name of the key in the target, by the first mapping matching it.
+func (m *keyMapper) mapKey(key string) string {
+	for i, mapping := range m.mappings {
+		if re := m.regexps[i]; re != nil {
+			if match := re.FindStringSubmatchIndex(key); match != nil {
+				return string(re.ExpandString(nil, mapping.To, key, match))
+			}
+		} else if mapping.From == key {
+			return mapping.To
+		}
+	}
+	return key
+}
+
+// ValidateKeyMappings returns an error when a mapping is malformed, or when mappings of literal keys collide.
+// Collisions of the keys mapped by regular expressions depend on the source and are only detected while propagating.
+func ValidateKeyMappings(mappings []kubegoodiesv1.KeyMapping) error {
+	if _, err := newKeyMapper(mappings); err != nil {
+		return err
+	}
+
+	mappedFrom := map[string]string{}
+	seen := map[string]bool{}
+	for _, mapping := range mappings {
+		if mapping.Regex {
+			continue
+		}
+		if seen[mapping.From] {
+			return fmt.Errorf("key %q is mapped more than once", mapping.From)
+		}
+		seen[mapping.From] = true
+
+		if errs := validation.IsConfigMapKey(mapping.To); len(errs) > 0 {
+			return fmt.Errorf("key %q is mapped to the invalid key %q: %s", mapping.From, mapping.To, strings.Join(errs, ", "))
+		}
+		if other, ok := mappedFrom[mapping.To]; ok {
+			return fmt.Errorf("keys %q and %q are both mapped to %q", other, mapping.From, mapping.To)
+		}
+		mappedFrom[mapping.To] = mapping.From
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package configmappropagation
+
+import (
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	kubegoodiesv1 "github.com/aliok/kubegoodies/api/v1"
+)
+
+func TestIsKeySelected(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *kubegoodiesv1.KeyFilter
+		key    string
+		want   bool
+	}{
+		{name: "no filter", filter: nil, key: "anything", want: true},
+		{name: "empty filter", filter: &kubegoodiesv1.KeyFilter{}, key: "anything", want: true},
+		{name: "included", filter: &kubegoodiesv1.KeyFilter{Include: []string{"public.*"}}, key: "public.url", want: true},
+		{name: "not included", filter: &kubegoodiesv1.KeyFilter{Include: []string{"public.*"}}, key: "internal.url", want: false},
+		{name: "excluded", filter: &kubegoodiesv1.KeyFilter{Exclude: []string{"*.secret"}}, key: "db.secret", want: false},
+		{name: "exclude wins", filter: &kubegoodiesv1.KeyFilter{Include: []string{"*.yaml"}, Exclude: []string{"*.internal.yaml"}}, key: "app.internal.yaml", want: false},
+		{name: "single character", filter: &kubegoodiesv1.KeyFilter{Include: []string{"v?"}}, key: "v1", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsKeySelected(tt.filter, tt.key); got != tt.want {
+				t.Errorf("IsKeySelected() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateKeyFilter(t *testing.T) {
+	if err := ValidateKeyFilter(&kubegoodiesv1.KeyFilter{Include: []string{"*.yaml"}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := ValidateKeyFilter(&kubegoodiesv1.KeyFilter{Exclude: []string{"[a-"}}); err == nil {
+		t.Errorf("expected an error for a malformed pattern")
+	}
+}
+
+func TestKeyMappings(t *testing.T) {
+	mappings := []kubegoodiesv1.KeyMapping{
+		{From: "url", To: "endpoint"},
+		{From: `app\.(.+)\.yaml`, To: "${1}.yaml", Regex: true},
+	}
+	if err := ValidateKeyMappings(mappings); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mapper, err := newKeyMapper(mappings)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for key, want := range map[string]string{"url": "endpoint", "app.prod.yaml": "prod.yaml", "other": "other", "myapp.prod.yaml": "myapp.prod.yaml"} {
+		if got := mapper.mapKey(key); got != want {
+			t.Errorf("mapKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+
+	for _, invalid := range [][]kubegoodiesv1.KeyMapping{
+		{{From: "a", To: "c"}, {From: "b", To: "c"}},
+		{{From: "a", To: "b"}, {From: "a", To: "c"}},
+		{{From: "a", To: "not/valid"}},
+		{{From: "(", To: "b", Regex: true}},
+	} {
+		if err := ValidateKeyMappings(invalid); err == nil {
+			t.Errorf("expected an error for %v", invalid)
+		}
+	}
+}
+
+func TestNewTargetKeyMappingCollision(t *testing.T) {
+	req := &Request{
+		TargetNamespace: "target",
+		TargetName:      "cm",
+		KeyMappings:     []kubegoodiesv1.KeyMapping{{From: "old-.*", To: "key", Regex: true}},
+	}
+	sourceCm := &corev1.ConfigMap{Data: map[string]string{"old-a": "1", "old-b": "2"}}
+
+	_, err := newTarget(req, sourceCm, nil)
+	var collisionErr *KeyMappingCollisionError
+	if !errors.As(err, &collisionErr) {
+		t.Fatalf("expected a KeyMappingCollisionError, got %v", err)
+	}
+	if collisionErr.MappedKey != "key" || len(collisionErr.Keys) != 2 {
+		t.Errorf("unexpected collision %v", collisionErr)
+	}
+}
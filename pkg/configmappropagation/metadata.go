@@ -0,0 +1,113 @@
+package configmappropagation
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	kubegoodiesv1 "github.com/aliok/kubegoodies/api/v1"
+)
+
+// toolAnnotationPatterns match the annotations tools keep on the source configmaps for their own bookkeeping.
+// On a target they would be wrong, and some tools would even consider the target as managed by them.
+var toolAnnotationPatterns = []string{
+	"kubectl.kubernetes.io/*",
+	"meta.helm.sh/*",
+	"argocd.argoproj.io/*",
+	"kapp.k14s.io/*",
+	"config.kubernetes.io/*",
+	"kustomize.toolkit.fluxcd.io/*",
+	"helm.toolkit.fluxcd.io/*",
+}
+
+// ValidateMetadataPolicy returns an error when a pattern of the policy is malformed.
+func ValidateMetadataPolicy(policy *kubegoodiesv1.MetadataPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	if err := ValidateKeyFilter(policy.Labels); err != nil {
+		return err
+	}
+	if err := ValidateKeyFilter(policy.Annotations); err != nil {
+		return err
+	}
+	for k, v := range policy.FixedLabels {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return fmt.Errorf("fixed label key %q is invalid: %s", k, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return fmt.Errorf("fixed label %q has the invalid value %q: %s", k, v, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// fixedLabels returns the labels that are set on all the targets of the policy.
+func fixedLabels(policy *kubegoodiesv1.MetadataPolicy) map[string]string {
+	if policy == nil || policy.FixedLabels == nil {
+		return map[string]string{ManagedByLabelKey: ManagedByLabelValue}
+	}
+	return policy.FixedLabels
+}
+
+// isMetadataKeySelected returns true when the label or annotation key is selected by the filter.
+// A nil filter selects all keys. Unlike with configmap keys, "*" matches the "/" of prefixed keys too.
+func isMetadataKeySelected(filter *kubegoodiesv1.KeyFilter, key string) bool {
+	if filter == nil {
+		return true
+	}
+	if len(filter.Include) > 0 && !matchesAnyMetadataKey(filter.Include, key) {
+		return false
+	}
+	return !matchesAnyMetadataKey(filter.Exclude, key)
+}
+
+// metadataKeySeparator replaces the "/" of prefixed keys and patterns, so that they are not matched like paths.
+// Label and annotation keys can't contain it.
+const metadataKeySeparator = "\x00"
+
+func matchesAnyMetadataKey(patterns []string, key string) bool {
+	escapedPatterns := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		escapedPatterns[i] = strings.ReplaceAll(pattern, "/", metadataKeySeparator)
+	}
+	return matchesAny(escapedPatterns, strings.ReplaceAll(key, "/", metadataKeySeparator))
+}
+
+// copiedLabels returns the labels of the source that are copied to the target, in a new map.
+func copiedLabels(policy *kubegoodiesv1.MetadataPolicy, labels map[string]string) map[string]string {
+	var filter *kubegoodiesv1.KeyFilter
+	if policy != nil {
+		filter = policy.Labels
+	}
+
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		if isMetadataKeySelected(filter, k) {
+			copied[k] = v
+		}
+	}
+	return copied
+}
+
+// copiedAnnotations returns the annotations of the source that are copied to the target, in a new map.
+func copiedAnnotations(policy *kubegoodiesv1.MetadataPolicy, annotations map[string]string) map[string]string {
+	var filter *kubegoodiesv1.KeyFilter
+	keepToolAnnotations := false
+	if policy != nil {
+		filter = policy.Annotations
+		keepToolAnnotations = policy.KeepToolAnnotations
+	}
+
+	copied := make(map[string]string, len(annotations))
+	for k, v := range annotations {
+		if !keepToolAnnotations && matchesAnyMetadataKey(toolAnnotationPatterns, k) {
+			continue
+		}
+		if isMetadataKeySelected(filter, k) {
+			copied[k] = v
+		}
+	}
+	return copied
+}
@@ -0,0 +1,168 @@
+package configmappropagation
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubegoodiesv1 "github.com/aliok/kubegoodies/api/v1"
+)
+
+func TestCopiedAnnotations(t *testing.T) {
+	annotations := map[string]string{
+		"kubectl.kubernetes.io/last-applied-configuration": "{}",
+		"meta.helm.sh/release-name":                        "release",
+		"example.com/owner":                                "team-a",
+		"example.com/internal":                             "true",
+		"description":                                      "settings",
+	}
+
+	tests := []struct {
+		name   string
+		policy *kubegoodiesv1.MetadataPolicy
+		want   map[string]string
+	}{
+		{
+			name: "tool annotations are stripped by default",
+			want: map[string]string{
+				"example.com/owner":    "team-a",
+				"example.com/internal": "true",
+				"description":          "settings",
+			},
+		},
+		{
+			name: "filtered by prefix",
+			policy: &kubegoodiesv1.MetadataPolicy{
+				Annotations: &kubegoodiesv1.KeyFilter{Include: []string{"example.com/*"}, Exclude: []string{"*/internal"}},
+			},
+			want: map[string]string{"example.com/owner": "team-a"},
+		},
+		{
+			name: "all prefixed keys matched by a single star",
+			policy: &kubegoodiesv1.MetadataPolicy{
+				Annotations: &kubegoodiesv1.KeyFilter{Include: []string{"*"}, Exclude: []string{"*-internal", "*/internal"}},
+			},
+			want: map[string]string{
+				"example.com/owner": "team-a",
+				"description":       "settings",
+			},
+		},
+		{
+			name: "tool annotations kept",
+			policy: &kubegoodiesv1.MetadataPolicy{
+				Annotations:         &kubegoodiesv1.KeyFilter{Include: []string{"kubectl.kubernetes.io/*"}},
+				KeepToolAnnotations: true,
+			},
+			want: map[string]string{"kubectl.kubernetes.io/last-applied-configuration": "{}"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := copiedAnnotations(tt.policy, annotations); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("copiedAnnotations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCopiedLabels(t *testing.T) {
+	labels := map[string]string{
+		"app":                         "x",
+		"app.kubernetes.io/name":      "x",
+		"tier":                        "dev",
+		"example.com/secret-internal": "true",
+	}
+
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    map[string]string
+	}{
+		{
+			name:    "excluded by prefix",
+			exclude: []string{"app.kubernetes.io/*", "example.com/*"},
+			want:    map[string]string{"app": "x", "tier": "dev"},
+		},
+		{
+			name:    "star matches across the prefix",
+			include: []string{"app*"},
+			want:    map[string]string{"app": "x", "app.kubernetes.io/name": "x"},
+		},
+		{
+			name:    "suffix of prefixed keys",
+			exclude: []string{"*-internal"},
+			want:    map[string]string{"app": "x", "app.kubernetes.io/name": "x", "tier": "dev"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &kubegoodiesv1.MetadataPolicy{Labels: &kubegoodiesv1.KeyFilter{Include: tt.include, Exclude: tt.exclude}}
+			if got := copiedLabels(policy, labels); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("copiedLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFixedLabels(t *testing.T) {
+	source := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "src",
+			Name:      "cm",
+			Labels:    map[string]string{"app": "x", "team": "source"},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		policy *kubegoodiesv1.MetadataPolicy
+		want   map[string]string
+	}{
+		{
+			name: "managed-by by default",
+			want: map[string]string{"app": "x", "team": "source", ManagedByLabelKey: ManagedByLabelValue},
+		},
+		{
+			name:   "managed-by without fixed labels",
+			policy: &kubegoodiesv1.MetadataPolicy{Labels: &kubegoodiesv1.KeyFilter{Include: []string{"app"}}},
+			want:   map[string]string{"app": "x", ManagedByLabelKey: ManagedByLabelValue},
+		},
+		{
+			name:   "fixed labels replace managed-by and win over the source",
+			policy: &kubegoodiesv1.MetadataPolicy{FixedLabels: map[string]string{"team": "platform", "propagated": "true"}},
+			want:   map[string]string{"app": "x", "team": "platform", "propagated": "true"},
+		},
+		{
+			name:   "no fixed labels",
+			policy: &kubegoodiesv1.MetadataPolicy{FixedLabels: map[string]string{}},
+			want:   map[string]string{"app": "x", "team": "source"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Request{SourceNamespace: "src", SourceName: "cm", TargetNamespace: "t", TargetName: "cm", MetadataPolicy: tt.policy}
+			desired, err := newTarget(req, source, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(desired.Labels, tt.want) {
+				t.Errorf("unexpected labels %v, want %v", desired.Labels, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateMetadataPolicyFixedLabels(t *testing.T) {
+	valid := &kubegoodiesv1.MetadataPolicy{FixedLabels: map[string]string{"example.com/team": "platform"}}
+	if err := ValidateMetadataPolicy(valid); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, labels := range []map[string]string{{"in valid": "x"}, {"team": "in valid"}} {
+		if err := ValidateMetadataPolicy(&kubegoodiesv1.MetadataPolicy{FixedLabels: labels}); err == nil {
+			t.Errorf("expected an error for the fixed labels %v", labels)
+		}
+	}
+}
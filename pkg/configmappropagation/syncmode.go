@@ -0,0 +1,119 @@
+package configmappropagation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kubegoodiesv1 "github.com/aliok/kubegoodies/api/v1"
+)
+
+func isMerge(req *Request) bool {
+	return req.SyncMode == kubegoodiesv1.SyncModeMerge
+}
+
+// setOwnedKeys records the keys of the data and binary data of the target on it.
+func setOwnedKeys(cm *corev1.ConfigMap) {
+	var keys []string
+	for k := range cm.Data {
+		keys = append(keys, k)
+	}
+	for k := range cm.BinaryData {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	cm.Annotations[PropagationAnnotationOwnedKeysKey] = strings.Join(keys, ",")
+}
+
+// ownedKeys returns the keys recorded on the target by setOwnedKeys.
+func ownedKeys(cm *corev1.ConfigMap) []string {
+	value := cm.Annotations[PropagationAnnotationOwnedKeysKey]
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// staleKeys returns the sorted keys of the existing target that are to be removed as they are not in the desired target.
+// When replacing, these are all the keys not in the desired target. When merging, these are only the keys that
+// came from the source before.
+func staleKeys(req *Request, existing *corev1.ConfigMap, desired *corev1.ConfigMap) []string {
+	candidates := ownedKeys(existing)
+	if !isMerge(req) {
+		candidates = nil
+		for k := range existing.Data {
+			candidates = append(candidates, k)
+		}
+		for k := range existing.BinaryData {
+			candidates = append(candidates, k)
+		}
+	}
+
+	var keys []string
+	for _, k := range candidates {
+		_, inData := existing.Data[k]
+		_, inBinaryData := existing.BinaryData[k]
+		if !inData && !inBinaryData {
+			continue
+		}
+		if _, ok := desired.Data[k]; ok {
+			continue
+		}
+		if _, ok := desired.BinaryData[k]; ok {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// removeKeys removes the keys from the data and binary data of the existing target.
+// Applying the target only removes the keys we applied before and nobody else changed, while
+// these keys may be managed by someone else.
+func removeKeys(ctx context.Context, cl client.Client, existing *corev1.ConfigMap, keys []string) error {
+	removedData := map[string]interface{}{}
+	removedBinaryData := map[string]interface{}{}
+	for _, k := range keys {
+		if _, ok := existing.Data[k]; ok {
+			removedData[k] = nil
+		}
+		if _, ok := existing.BinaryData[k]; ok {
+			removedBinaryData[k] = nil
+		}
+	}
+
+	content := map[string]interface{}{}
+	if len(removedData) > 0 {
+		content["data"] = removedData
+	}
+	if len(removedBinaryData) > 0 {
+		content["binaryData"] = removedBinaryData
+	}
+	patch, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+
+	cm := existing.DeepCopy()
+	if err := cl.Patch(ctx, cm, client.RawPatch(types.MergePatchType, patch), client.FieldOwner(FieldManager)); err != nil {
+		return fmt.Errorf("error removing the keys %s from the target configmap: %v", strings.Join(keys, ", "), err)
+	}
+	return nil
+}
+
+// containsBytes returns true when all entries of sub are in m.
+func containsBytes(m map[string][]byte, sub map[string][]byte) bool {
+	for k, v := range sub {
+		if actual, ok := m[k]; !ok || string(actual) != string(v) {
+			return false
+		}
+	}
+	return true
+}
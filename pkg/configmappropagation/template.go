@@ -0,0 +1,78 @@
+package configmappropagation
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// TemplateData is what the data values can refer to when templating is enabled.
+type TemplateData struct {
+	Namespace TemplateNamespace
+	Vars      map[string]string
+}
+
+// TemplateNamespace is the target namespace as seen by the templates.
+type TemplateNamespace struct {
+	Name        string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+func newTemplateData(req *Request, targetNs *corev1.Namespace) TemplateData {
+	vars := map[string]string{}
+	if req.Template != nil && req.Template.Variables != nil {
+		vars = req.Template.Variables
+	}
+	return TemplateData{
+		Namespace: TemplateNamespace{
+			Name:        targetNs.Name,
+			Labels:      nonNil(targetNs.Labels),
+			Annotations: nonNil(targetNs.Annotations),
+		},
+		Vars: vars,
+	}
+}
+
+// templateFuncs are the functions the templates can call besides the builtin ones.
+var templateFuncs = template.FuncMap{
+	"mustIndex": mustIndex,
+}
+
+// mustIndex returns the value of the key like the builtin index, but fails for missing keys instead of
+// returning "". Keys like prefixed labels can't be referred to with dots, which fail for missing keys.
+func mustIndex(m map[string]string, key string) (string, error) {
+	v, ok := m[key]
+	if !ok {
+		return "", fmt.Errorf("map has no entry for key %q", key)
+	}
+	return v, nil
+}
+
+// renderValues renders each value of the data as a template, in place.
+func renderValues(data map[string]string, templateData TemplateData, target types.NamespacedName) error {
+	for k, v := range data {
+		tmpl, err := template.New(k).Option("missingkey=error").Funcs(templateFuncs).Parse(v)
+		if err != nil {
+			return &TemplateRenderError{Target: target, Key: k, Err: err}
+		}
+
+		var b strings.Builder
+		if err := tmpl.Execute(&b, templateData); err != nil {
+			return &TemplateRenderError{Target: target, Key: k, Err: err}
+		}
+		data[k] = b.String()
+	}
+	return nil
+}
+
+// nonNil returns an empty map for nil, so that the templates see missing keys as errors instead of nil maps.
+func nonNil(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
@@ -0,0 +1,70 @@
+package configmappropagation
+
+import (
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubegoodiesv1 "github.com/aliok/kubegoodies/api/v1"
+)
+
+func TestNewTargetTemplate(t *testing.T) {
+	req := &Request{
+		TargetNamespace: "team-a",
+		TargetName:      "cm",
+		Template: &kubegoodiesv1.ValueTemplate{
+			Enabled:   true,
+			Variables: map[string]string{"clusterDomain": "cluster.local"},
+		},
+	}
+	targetNs := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"tenant": "42", "example.com/team": "a"}}}
+	sourceCm := &corev1.ConfigMap{
+		Data: map[string]string{
+			"url":    "http://svc.{{.Namespace.Name}}.svc.{{.Vars.clusterDomain}}",
+			"tenant": `{{index .Namespace.Labels "tenant"}}`,
+			"team":   `{{mustIndex .Namespace.Labels "example.com/team"}}`,
+		},
+		BinaryData: map[string][]byte{"raw": []byte("{{.Namespace.Name}}")},
+	}
+
+	desired, err := newTarget(req, sourceCm, targetNs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := desired.Data["url"]; got != "http://svc.team-a.svc.cluster.local" {
+		t.Errorf("unexpected url %q", got)
+	}
+	if got := desired.Data["tenant"]; got != "42" {
+		t.Errorf("unexpected tenant %q", got)
+	}
+	if got := desired.Data["team"]; got != "a" {
+		t.Errorf("unexpected team %q", got)
+	}
+	if got := string(desired.BinaryData["raw"]); got != "{{.Namespace.Name}}" {
+		t.Errorf("expected binary data not to be rendered, got %q", got)
+	}
+	if sourceCm.Data["tenant"] != `{{index .Namespace.Labels "tenant"}}` {
+		t.Errorf("expected the source not to be modified")
+	}
+
+	for _, missing := range []string{"{{.Vars.missing}}", `{{mustIndex .Namespace.Labels "example.com/missing"}}`} {
+		sourceCm.Data["missing"] = missing
+		_, err = newTarget(req, sourceCm, targetNs)
+		var renderErr *TemplateRenderError
+		if !errors.As(err, &renderErr) || renderErr.Key != "missing" {
+			t.Fatalf("expected a TemplateRenderError for key missing of %s, got %v", missing, err)
+		}
+	}
+
+	// unlike mustIndex, the builtin index doesn't fail for missing keys
+	sourceCm.Data["missing"] = `{{index .Namespace.Labels "example.com/missing"}}`
+	desired, err = newTarget(req, sourceCm, targetNs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := desired.Data["missing"]; got != "" {
+		t.Errorf("expected an empty value for the missing key, got %q", got)
+	}
+}
@@ -1,16 +1,185 @@
 package configmappropagation
 
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kubegoodiesv1 "github.com/aliok/kubegoodies/api/v1"
+)
+
+// FieldManager is the field manager the targets are applied with.
+const FieldManager = "kubegoodies"
+
 const (
 	PropagationAnnotationNamespaceKey = "kubegoodies-configmap-propagation-source-namespace"
 	PropagationAnnotationNameKey      = "kubegoodies-configmap-propagation-source-name"
+	PropagationAnnotationOwnerNameKey = "kubegoodies-configmap-propagation-owner-name"
+	PropagationAnnotationOwnerUIDKey  = "kubegoodies-configmap-propagation-owner-uid"
+
+	// PropagationAnnotationContentHashKey is the hash of the content the target was last propagated with.
+	PropagationAnnotationContentHashKey = "kubegoodies-configmap-propagation-content-hash"
+
+	// PropagationAnnotationSourceHashKey is the hash of the sources the target was last propagated from.
+	PropagationAnnotationSourceHashKey = "kubegoodies-configmap-propagation-source-hash"
+
+	// PropagationAnnotationOwnedKeysKey is the comma separated list of the keys that came from the source,
+	// set when merging the source into the target.
+	PropagationAnnotationOwnedKeysKey = "kubegoodies-configmap-propagation-owned-keys"
+
+	// PropagationAnnotationSourcesKey is set on aggregated targets instead of the source annotations.
+	// It is a JSON object of the keys of the target each source provided, by namespace/name of the source.
+	PropagationAnnotationSourcesKey = "kubegoodies-configmap-propagation-sources"
+)
+
+// ManagedByLabelKey and ManagedByLabelValue label the targets, so that they can be queried, unless
+// the metadata policy of the request sets other fixed labels.
+const (
+	ManagedByLabelKey   = "app.kubernetes.io/managed-by"
+	ManagedByLabelValue = "kubegoodies"
 )
 
 type Request struct {
 	SourceNamespace string
 	SourceName      string
+	// Source is the source configmap, loaded once for all the requests propagating it.
+	// When nil, the source is fetched while executing the request. It must not be modified.
+	Source          *corev1.ConfigMap `json:"-"`
 	TargetNamespace string
 	TargetName      string
-	//  TODO: mod?
+	// OwnerName and OwnerUID identify the ConfigMapPropagation the request belongs to.
+	// When set, the target is owned by that ConfigMapPropagation.
+	OwnerName string
+	OwnerUID  types.UID
+	// ConflictPolicy defines what happens when the target exists and is not managed by any ConfigMapPropagation.
+	ConflictPolicy kubegoodiesv1.ConflictPolicy
+	// Preempt allows taking the target over from another ConfigMapPropagation, which is set when the owner of the
+	// request outranks the other ConfigMapPropagations claiming the target.
+	// Without it, targets managed by another ConfigMapPropagation are only taken over with the Overwrite policy.
+	Preempt bool
+	// DriftPolicy defines what happens when the target was changed while the source stayed the same.
+	DriftPolicy kubegoodiesv1.DriftPolicy
+	// Keys selects the keys of the source that are propagated. When nil, all keys are propagated.
+	Keys *kubegoodiesv1.KeyFilter
+	// KeyMappings renames the keys of the source in the target.
+	KeyMappings []kubegoodiesv1.KeyMapping
+	// Template renders the data values of the source for the target namespace, when enabled.
+	Template *kubegoodiesv1.ValueTemplate
+	// Overrides are merged on top of the content of the source, in their order.
+	Overrides []kubegoodiesv1.TargetOverride
+	// MetadataPolicy selects the labels and annotations of the source that are copied to the target.
+	MetadataPolicy *kubegoodiesv1.MetadataPolicy
+	// SyncMode defines what happens to the keys of the target that don't come from the source. Defaults to Replace.
+	SyncMode kubegoodiesv1.SyncMode
+	// Aggregated are the source configmaps merged into the target when aggregating, in their merge order.
+	// SourceNamespace, SourceName and Source are not used then. They must not be modified.
+	Aggregated []*corev1.ConfigMap `json:"-"`
+	// KeyCollisionStrategy defines what happens when aggregated sources have the same key. Defaults to Error.
+	KeyCollisionStrategy kubegoodiesv1.KeyCollisionStrategy
+	// PrefixSourceNamespace prefixes the keys with the namespace of their source too when prefixing them with the
+	// source name, as sources in different namespaces can have the same name.
+	PrefixSourceNamespace bool
+}
+
+// Sources returns the namespace/name of the sources of the request.
+func (r *Request) Sources() []string {
+	if len(r.Aggregated) == 0 {
+		return []string{types.NamespacedName{Namespace: r.SourceNamespace, Name: r.SourceName}.String()}
+	}
+
+	sources := make([]string, 0, len(r.Aggregated))
+	for _, src := range r.Aggregated {
+		sources = append(sources, client.ObjectKeyFromObject(src).String())
+	}
+	return sources
 }
 
 type Result string
+
+const (
+	ResultCreated   Result = "Created"
+	ResultUpdated   Result = "Updated"
+	ResultUnchanged Result = "Unchanged"
+	ResultDeleted   Result = "Deleted"
+	ResultSkipped   Result = "Skipped"
+
+	// ResultDriftDetected is returned when the target drifted from the source and was left alone.
+	ResultDriftDetected Result = "DriftDetected"
+	// ResultDriftReverted is returned when the target drifted from the source and was restored.
+	ResultDriftReverted Result = "DriftReverted"
+)
+
+// Outcome is what executing a request did to the target.
+type Outcome struct {
+	Result Result
+	// SourceChanged is true when the target was updated because its sources changed since it was last
+	// propagated, rather than because of a change of the propagation or a new target.
+	SourceChanged bool
+	// SourceMissing is true when the source doesn't exist or is being deleted, so that the target is
+	// deleted instead of written.
+	SourceMissing bool
+}
+
+// ConflictError is returned when the target configmap exists, is not propagated by the request
+// and the conflict policy of the request doesn't allow taking it over.
+type ConflictError struct {
+	Target types.NamespacedName
+	// Owner is the description of who manages the existing target.
+	Owner string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("target configmap %s already exists and is %s", e.Target, e.Owner)
+}
+
+// FieldManagerConflictError is returned when applying the target conflicts with fields owned by another field manager.
+type FieldManagerConflictError struct {
+	Target  types.NamespacedName
+	Message string
+}
+
+func (e *FieldManagerConflictError) Error() string {
+	return fmt.Sprintf("target configmap %s has fields owned by another field manager: %s", e.Target, e.Message)
+}
+
+// KeyMappingCollisionError is returned when multiple keys of the source are mapped to the same key of the target.
+type KeyMappingCollisionError struct {
+	Target    types.NamespacedName
+	Keys      []string
+	MappedKey string
+}
+
+func (e *KeyMappingCollisionError) Error() string {
+	return fmt.Sprintf("keys %s of the source are all mapped to the key %q of target configmap %s", strings.Join(e.Keys, ", "), e.MappedKey, e.Target)
+}
+
+// KeyCollisionError is returned when aggregated sources have the same key and the key collision strategy
+// of the request doesn't allow picking one of them.
+type KeyCollisionError struct {
+	Target types.NamespacedName
+	Key    string
+	// Sources are the namespace/name of the sources having the key.
+	Sources []string
+}
+
+func (e *KeyCollisionError) Error() string {
+	return fmt.Sprintf("sources %s all have the key %q of target configmap %s", strings.Join(e.Sources, ", "), e.Key, e.Target)
+}
+
+// TemplateRenderError is returned when a data value of the source can't be rendered for the target.
+type TemplateRenderError struct {
+	Target types.NamespacedName
+	Key    string
+	Err    error
+}
+
+func (e *TemplateRenderError) Error() string {
+	return fmt.Sprintf("unable to render the value of key %q for target configmap %s: %v", e.Key, e.Target, e.Err)
+}
+
+func (e *TemplateRenderError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,59 @@
+package nametemplate
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Data is what a name template can refer to.
+type Data struct {
+	SourceNamespace string
+	SourceName      string
+	TargetNamespace string
+	PropagationName string
+}
+
+// sampleData is used for validating templates without a source at hand.
+var sampleData = Data{
+	SourceNamespace: "source-namespace",
+	SourceName:      "source-name",
+	TargetNamespace: "target-namespace",
+	PropagationName: "propagation-name",
+}
+
+// Parse parses a name template.
+func Parse(text string) (*template.Template, error) {
+	return template.New("nameTemplate").Option("missingkey=error").Parse(text)
+}
+
+// Render renders the name template and returns an error when the result is not a valid configmap name.
+func Render(tmpl *template.Template, data Data) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+
+	name := b.String()
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return "", fmt.Errorf("%q is not a valid configmap name: %s", name, strings.Join(errs, ", "))
+	}
+	return name, nil
+}
+
+// Validate parses the name template and renders it with sample data, so that templates referring to unknown
+// fields or producing invalid names are rejected before they are used. An empty template is valid.
+func Validate(text string) error {
+	if text == "" {
+		return nil
+	}
+
+	tmpl, err := Parse(text)
+	if err != nil {
+		return err
+	}
+	_, err = Render(tmpl, sampleData)
+	return err
+}
@@ -0,0 +1,42 @@
+package nametemplate
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  bool
+	}{
+		{name: "empty", template: "", wantErr: false},
+		{name: "source namespace and name", template: "{{.SourceNamespace}}-{{.SourceName}}", wantErr: false},
+		{name: "propagation name", template: "{{.PropagationName}}.{{.SourceName}}", wantErr: false},
+		{name: "unknown field", template: "{{.Unknown}}", wantErr: true},
+		{name: "malformed", template: "{{.SourceName", wantErr: true},
+		{name: "invalid name", template: "{{.SourceName}}_copy", wantErr: true},
+		{name: "empty name", template: "{{if false}}x{{end}}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Validate(tt.template); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	tmpl, err := Parse("{{.SourceNamespace}}-{{.SourceName}}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	name, err := Render(tmpl, Data{SourceNamespace: "team-a", SourceName: "config", TargetNamespace: "ns1", PropagationName: "pr"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name != "team-a-config" {
+		t.Errorf("expected team-a-config, got %s", name)
+	}
+}